@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//alignmentPadding records how much padding a segment needed to reach the alignment
+type alignmentPadding struct {
+	address uint32
+	length  int
+	before  uint64
+	after   uint64
+}
+
+//parseAlignOption parses ALIGNMENT[:FILL], fill defaults to 0xFF as erased flash
+func parseAlignOption(value string) (transform, error) {
+	parts := strings.SplitN(value, ":", 2)
+	alignment, err := parseNumberString(parts[0])
+	if err != nil {
+		return transform{}, err
+	}
+	if alignment == 0 {
+		return transform{}, fmt.Errorf("alignment must be at least 1 byte")
+	}
+	fill := byte(0xFF)
+	if len(parts) == 2 {
+		fill, err = parseByte(parts[1])
+		if err != nil {
+			return transform{}, err
+		}
+	}
+	return transform{
+		name: fmt.Sprintf("align segments to %d bytes with 0x%02X", alignment, fill),
+		apply: func(mem *gohex.Memory) error {
+			padding, err := alignSegments(mem, alignment, fill)
+			for _, p := range padding {
+				fmt.Printf("Segment @ 0x%08X ; len %d padded %d bytes before and %d bytes after\n", p.address, p.length, p.before, p.after)
+			}
+			return err
+		},
+	}, nil
+}
+
+//alignSegments expands every segment out to the alignment on both ends, filling with the fill byte
+//Segments that end up sharing an aligned block are joined together
+func alignSegments(mem *gohex.Memory, alignment uint32, fill byte) ([]alignmentPadding, error) {
+	a := uint64(alignment)
+	padding := []alignmentPadding{}
+	for _, segment := range mem.GetDataSegments() {
+		start := uint64(segment.Address)
+		end := start + uint64(len(segment.Data))
+		p := alignmentPadding{address: segment.Address, length: len(segment.Data)}
+		//Only gaps get filled, so a neighbour already filling the block isn't counted or overwritten
+		for _, gap := range memoryGaps(mem, addressRange{start: alignDown(start, a), end: start}) {
+			p.before += gap.end - gap.start
+		}
+		for _, gap := range memoryGaps(mem, addressRange{start: end, end: alignUp(end, a)}) {
+			p.after += gap.end - gap.start
+		}
+		if p.before == 0 && p.after == 0 {
+			continue
+		}
+		if alignUp(end, a) > 1<<32 {
+			return padding, fmt.Errorf("aligning segment @ 0x%08X runs past the end of the address space", segment.Address)
+		}
+		if err := fillGaps(mem, addressRange{start: alignDown(start, a), end: alignUp(end, a)}, fill); err != nil {
+			return padding, err
+		}
+		padding = append(padding, p)
+	}
+	return padding, nil
+}
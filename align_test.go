@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestAlignSegments(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x101, []byte{1, 2})
+	mem.AddBinary(0x106, []byte{3})
+	mem.AddBinary(0x200, []byte{4, 5, 6, 7})
+	padding, err := alignSegments(mem, 4, 0xFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPadding := []alignmentPadding{
+		{address: 0x101, length: 2, before: 1, after: 1},
+		{address: 0x106, length: 1, before: 2, after: 1},
+	}
+	if !reflect.DeepEqual(padding, wantPadding) {
+		t.Errorf("got %v, want %v", padding, wantPadding)
+	}
+	segments := mem.GetDataSegments()
+	want := []gohex.DataSegment{
+		{Address: 0x100, Data: []byte{0xFF, 1, 2, 0xFF, 0xFF, 0xFF, 3, 0xFF}},
+		{Address: 0x200, Data: []byte{4, 5, 6, 7}},
+	}
+	if !reflect.DeepEqual(segments, want) {
+		t.Errorf("got %v, want %v", segments, want)
+	}
+}
+
+func TestParseAlignOption(t *testing.T) {
+	t.Parallel()
+	for _, value := range []string{"0", "x", "4:0x100"} {
+		if _, err := parseAlignOption(value); err == nil {
+			t.Errorf("Should raise error on --align=%s", value)
+		}
+	}
+	align, err := parseAlignOption("256:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if align.name != "align segments to 256 bytes with 0x00" {
+		t.Errorf("got %v", align.name)
+	}
+}
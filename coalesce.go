@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//parseGapOption parses LENGTH[:FILL] for --coalesce and --split-fill, fill defaults to 0xFF as erased flash
+func parseGapOption(value string) (uint32, byte, error) {
+	parts := strings.SplitN(value, ":", 2)
+	length, err := parseNumberString(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if length == 0 {
+		return 0, 0, fmt.Errorf("length must be at least 1 byte")
+	}
+	fill := byte(0xFF)
+	if len(parts) == 2 {
+		if fill, err = parseByte(parts[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return length, fill, nil
+}
+
+//parseCoalesceOption parses LENGTH[:FILL], joining segments less than LENGTH bytes apart
+func parseCoalesceOption(value string) (transform, error) {
+	maxGap, fill, err := parseGapOption(value)
+	if err != nil {
+		return transform{}, err
+	}
+	return transform{
+		name: fmt.Sprintf("join segments less than %d bytes apart with 0x%02X", maxGap, fill),
+		apply: func(mem *gohex.Memory) error {
+			for _, gap := range coalesceSegments(mem, maxGap, fill) {
+				fmt.Printf("Filled gap %v\n", gap)
+			}
+			return nil
+		},
+	}, nil
+}
+
+//parseSplitFillOption parses LENGTH[:FILL], splitting segments where more than LENGTH fill bytes run together
+func parseSplitFillOption(value string) (transform, error) {
+	minRun, fill, err := parseGapOption(value)
+	if err != nil {
+		return transform{}, err
+	}
+	return transform{
+		name: fmt.Sprintf("split segments at more than %d bytes of 0x%02X", minRun, fill),
+		apply: func(mem *gohex.Memory) error {
+			for _, run := range splitFillRuns(mem, minRun, fill) {
+				fmt.Printf("Removed %d bytes of 0x%02X %v\n", run.end-run.start, fill, run)
+			}
+			return nil
+		},
+	}, nil
+}
+
+//coalesceSegments fills every gap between segments shorter than maxGap, returning the gaps filled
+func coalesceSegments(mem *gohex.Memory, maxGap uint32, fill byte) []addressRange {
+	filled := []addressRange{}
+	segments := mem.GetDataSegments()
+	for i := 1; i < len(segments); i++ {
+		gap := addressRange{start: uint64(segments[i-1].Address) + uint64(len(segments[i-1].Data)), end: uint64(segments[i].Address)}
+		if gap.end > gap.start && gap.end-gap.start < uint64(maxGap) {
+			filled = append(filled, gap)
+		}
+	}
+	for _, gap := range filled {
+		mem.AddBinary(uint32(gap.start), repeatByte(fill, int(gap.end-gap.start)))
+	}
+	return filled
+}
+
+//splitFillRuns removes runs of more than minRun fill bytes from inside segments, returning the runs removed
+//Runs at either end of a segment are left alone, --trim handles those
+func splitFillRuns(mem *gohex.Memory, minRun uint32, fill byte) []addressRange {
+	runs := []addressRange{}
+	for _, segment := range mem.GetDataSegments() {
+		runStart := -1
+		for i, b := range segment.Data {
+			if b == fill {
+				if runStart < 0 {
+					runStart = i
+				}
+				continue
+			}
+			if runStart > 0 && uint32(i-runStart) > minRun {
+				runs = append(runs, addressRange{start: uint64(segment.Address) + uint64(runStart), end: uint64(segment.Address) + uint64(i)})
+			}
+			runStart = -1
+		}
+	}
+	for _, run := range runs {
+		mem.RemoveBinary(uint32(run.start), uint32(run.end-run.start))
+	}
+	return runs
+}
+
+//trimSettings are shared by every --trim, so --trim-drop-empty applies wherever it is given
+type trimSettings struct {
+	dropEmpty bool
+}
+
+//parseTrimOption parses the fill byte for --trim, default 0xFF as erased flash
+func parseTrimOption(value string, settings *trimSettings) (transform, error) {
+	fill := byte(0xFF)
+	if value != "" {
+		var err error
+		if fill, err = parseByte(value); err != nil {
+			return transform{}, err
+		}
+	}
+	return transform{
+		name: fmt.Sprintf("trim 0x%02X from segment ends", fill),
+		apply: func(mem *gohex.Memory) error {
+			trimmed, dropped := trimSegments(mem, fill, settings.dropEmpty)
+			fmt.Printf("Trimmed %d bytes", trimmed)
+			if settings.dropEmpty {
+				fmt.Printf(", dropped %d segments of only 0x%02X", dropped, fill)
+			}
+			fmt.Printf("\n")
+			return nil
+		},
+	}, nil
+}
+
+//trimSegments removes leading and trailing fill bytes from every segment, returning how many bytes were removed
+//Segments holding nothing but fill are kept as they are unless dropEmpty is set
+func trimSegments(mem *gohex.Memory, fill byte, dropEmpty bool) (uint64, int) {
+	removed := []addressRange{}
+	trimmed := uint64(0)
+	dropped := 0
+	for _, segment := range mem.GetDataSegments() {
+		start := 0
+		for start < len(segment.Data) && segment.Data[start] == fill {
+			start++
+		}
+		if start == len(segment.Data) {
+			if dropEmpty {
+				removed = append(removed, addressRange{start: uint64(segment.Address), end: uint64(segment.Address) + uint64(len(segment.Data))})
+				dropped++
+			}
+			continue
+		}
+		end := len(segment.Data)
+		for segment.Data[end-1] == fill {
+			end--
+		}
+		if start > 0 {
+			removed = append(removed, addressRange{start: uint64(segment.Address), end: uint64(segment.Address) + uint64(start)})
+			trimmed += uint64(start)
+		}
+		if end < len(segment.Data) {
+			removed = append(removed, addressRange{start: uint64(segment.Address) + uint64(end), end: uint64(segment.Address) + uint64(len(segment.Data))})
+			trimmed += uint64(len(segment.Data) - end)
+		}
+	}
+	for _, r := range removed {
+		mem.RemoveBinary(uint32(r.start), uint32(r.end-r.start))
+	}
+	return trimmed, dropped
+}
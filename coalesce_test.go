@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestCoalesceSegments(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x100, []byte{1, 2})
+	mem.AddBinary(0x104, []byte{3}) //2 byte gap, joined
+	mem.AddBinary(0x110, []byte{4}) //11 byte gap, kept
+	mem.AddBinary(0x118, []byte{5}) //7 byte gap, joined
+	filled := coalesceSegments(mem, 8, 0xEE)
+	want := []addressRange{{0x102, 0x104}, {0x111, 0x118}}
+	if !reflect.DeepEqual(filled, want) {
+		t.Errorf("got %v, want %v", filled, want)
+	}
+	segments := mem.GetDataSegments()
+	if len(segments) != 2 || !bytes.Equal(segments[0].Data, []byte{1, 2, 0xEE, 0xEE, 3}) || segments[1].Address != 0x110 || len(segments[1].Data) != 9 {
+		t.Errorf("got %v", segments)
+	}
+}
+
+func TestSplitFillRuns(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	data := []byte{0xFF, 0xFF, 0xFF, 0xFF, 1, 0xFF, 0xFF, 2, 0xFF, 0xFF, 0xFF, 0xFF, 3, 0xFF, 0xFF, 0xFF, 0xFF}
+	mem.AddBinary(0x1000, data)
+	runs := splitFillRuns(mem, 3, 0xFF)
+	if want := []addressRange{{0x1008, 0x100C}}; !reflect.DeepEqual(runs, want) {
+		t.Errorf("got %v, want %v", runs, want)
+	}
+	segments := mem.GetDataSegments()
+	if len(segments) != 2 || !bytes.Equal(segments[0].Data, data[:8]) || segments[1].Address != 0x100C || !bytes.Equal(segments[1].Data, data[12:]) {
+		t.Errorf("got %v", segments)
+	}
+}
+
+func TestParseGapOption(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		value   string
+		length  uint32
+		fill    byte
+		wantErr bool
+	}{
+		{"16", 16, 0xFF, false},
+		{"0x100:0", 0x100, 0, false},
+		{"0", 0, 0, true},
+		{"16:0x100", 0, 0, true},
+		{"x", 0, 0, true},
+	}
+	for _, tt := range tests {
+		length, fill, err := parseGapOption(tt.value)
+		if (err != nil) != tt.wantErr || length != tt.length || fill != tt.fill {
+			t.Errorf("%s got %d 0x%02X %v", tt.value, length, fill, err)
+		}
+	}
+}
+
+func TestTrimSegments(t *testing.T) {
+	t.Parallel()
+	for _, dropEmpty := range []bool{false, true} {
+		mem := gohex.NewMemory()
+		mem.AddBinary(0x1000, []byte{0xFF, 0xFF, 1, 0xFF, 2, 0xFF})
+		mem.AddBinary(0x2000, []byte{0xFF, 0xFF, 0xFF})
+		mem.AddBinary(0x3000, []byte{3})
+		trimmed, dropped := trimSegments(mem, 0xFF, dropEmpty)
+		if trimmed != 3 {
+			t.Errorf("trimmed %d bytes, want 3", trimmed)
+		}
+		want := []gohex.DataSegment{{Address: 0x1002, Data: []byte{1, 0xFF, 2}}, {Address: 0x2000, Data: []byte{0xFF, 0xFF, 0xFF}}, {Address: 0x3000, Data: []byte{3}}}
+		if dropEmpty {
+			want = append(want[:1], want[2])
+			if dropped != 1 {
+				t.Errorf("dropped %d segments, want 1", dropped)
+			}
+		}
+		got := mem.GetDataSegments()
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i].Address != want[i].Address || !bytes.Equal(got[i].Data, want[i].Data) {
+				t.Errorf("got %v, want %v", got[i], want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//compressHeaderBytes is the size header placed before compressed data stored in the image:
+//u32 compressed length then u32 original length, both little endian
+const compressHeaderBytes = 8
+
+//compressSettings are shared by every --compress, so --compress-fill and --dry-run apply wherever they are given
+type compressSettings struct {
+	fill   byte
+	dryRun bool //Only say what would be written to files
+}
+
+//compressor turns a block of data into one compressed stream
+type compressor func(data []byte) ([]byte, error)
+
+//newCompressor looks up the algorithm, heatshrink takes its window and lookahead bits as heatshrink-W-L (default 8 and 4)
+func newCompressor(algorithm string) (compressor, error) {
+	switch algorithm {
+	case "deflate":
+		return func(data []byte) ([]byte, error) {
+			var out bytes.Buffer
+			w, _ := flate.NewWriter(&out, flate.BestCompression)
+			w.Write(data)
+			err := w.Close()
+			return out.Bytes(), err
+		}, nil
+	case "zlib":
+		return func(data []byte) ([]byte, error) {
+			var out bytes.Buffer
+			w, _ := zlib.NewWriterLevel(&out, zlib.BestCompression)
+			w.Write(data)
+			err := w.Close()
+			return out.Bytes(), err
+		}, nil
+	case "lz4":
+		return func(data []byte) ([]byte, error) { return lz4Block(data), nil }, nil
+	case "lzma":
+		return func(data []byte) ([]byte, error) { return lzmaCompress(data), nil }, nil
+	}
+	if strings.HasPrefix(algorithm, "heatshrink") {
+		window, lookahead := 8, 4
+		if params := strings.TrimPrefix(algorithm, "heatshrink"); params != "" {
+			parts := strings.Split(params, "-")
+			if len(parts) != 3 || parts[0] != "" {
+				return nil, fmt.Errorf("heatshrink parameters should be heatshrink-WINDOW-LOOKAHEAD")
+			}
+			var err error
+			if window, err = strconv.Atoi(parts[1]); err != nil {
+				return nil, err
+			}
+			if lookahead, err = strconv.Atoi(parts[2]); err != nil {
+				return nil, err
+			}
+		}
+		if window < 4 || window > 15 || lookahead < 3 || lookahead >= window {
+			return nil, fmt.Errorf("heatshrink window should be 4-15 bits and lookahead 3 bits up to one less than the window")
+		}
+		return func(data []byte) ([]byte, error) { return heatshrinkCompress(data, window, lookahead), nil }, nil
+	}
+	return nil, fmt.Errorf("unknown algorithm %s, should be deflate, zlib, lz4, heatshrink[-W-L] or lzma", algorithm)
+}
+
+//parseCompressOption parses ALG:RANGE:@ADDR or ALG:RANGE:FILE for --compress
+//Writing to a file leaves the image alone, storing it at an address replaces the range with the size header and compressed data
+func parseCompressOption(value string, settings *compressSettings) (transform, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return transform{}, fmt.Errorf("should be ALG:RANGE:@ADDRESS or ALG:RANGE:FILE")
+	}
+	algorithm := strings.ToLower(parts[0])
+	compress, err := newCompressor(algorithm)
+	if err != nil {
+		return transform{}, err
+	}
+	r, err := parseAddressRange(parts[1])
+	if err != nil {
+		return transform{}, err
+	}
+	destination := parts[2]
+	if !strings.HasPrefix(destination, "@") {
+		return transform{
+			name: fmt.Sprintf("%s compress %v to %s", algorithm, r, destination),
+			apply: func(mem *gohex.Memory) error {
+				compressed, original, err := compressMemory(mem, imageRange(mem, r), settings.fill, compress)
+				if err != nil {
+					return err
+				}
+				if settings.dryRun {
+					fmt.Printf("Would write %d bytes compressed from %d to %s\n", len(compressed), original, destination)
+					return nil
+				}
+				fmt.Printf("Compressed %d bytes to %d\n", original, len(compressed))
+				return ioutil.WriteFile(destination, compressed, 0644)
+			},
+		}, nil
+	}
+	address, err := parseNumberString(destination[1:])
+	if err != nil {
+		return transform{}, err
+	}
+	return transform{
+		name: fmt.Sprintf("%s compress %v to 0x%08X", algorithm, r, address),
+		apply: func(mem *gohex.Memory) error {
+			return storeCompressed(mem, imageRange(mem, r), address, settings.fill, compress)
+		},
+	}, nil
+}
+
+//compressMemory compresses the range with gaps read as fill, returning the compressed data and the original length
+func compressMemory(mem *gohex.Memory, r addressRange, fill byte, compress compressor) ([]byte, int, error) {
+	if r.end <= r.start {
+		return nil, 0, fmt.Errorf("nothing to compress")
+	}
+	data := readMemory(mem, r, fill)
+	compressed, err := compress(data)
+	return compressed, len(data), err
+}
+
+//storeCompressed replaces the range with its compressed copy at address, which may be inside the range
+func storeCompressed(mem *gohex.Memory, r addressRange, address uint32, fill byte, compress compressor) error {
+	compressed, original, err := compressMemory(mem, r, fill, compress)
+	if err != nil {
+		return err
+	}
+	target := addressRange{start: uint64(address), end: uint64(address) + compressHeaderBytes + uint64(len(compressed))}
+	if target.end > 1<<32 {
+		return fmt.Errorf("compressed data @ 0x%08X runs past the end of the address space", address)
+	}
+	//The range itself is replaced so only the target outside it can collide, checked before anything changes
+	outside := []addressRange{{start: target.start, end: target.end}, {start: target.start, end: target.end}}
+	if outside[0].end > r.start {
+		outside[0].end = r.start
+	}
+	if outside[1].start < r.end {
+		outside[1].start = r.end
+	}
+	for _, part := range outside {
+		if part.end <= part.start {
+			continue
+		}
+		if existing, b, found := findData(mem, part, fill); found {
+			return fmt.Errorf("compressed data would overwrite data 0x%02X @ 0x%08X", b, existing)
+		}
+	}
+	mem.RemoveBinary(uint32(r.start), uint32(r.end-r.start))
+	stored := make([]byte, compressHeaderBytes, target.end-target.start)
+	binary.LittleEndian.PutUint32(stored[0:], uint32(len(compressed)))
+	binary.LittleEndian.PutUint32(stored[4:], uint32(original))
+	stored = append(stored, compressed...)
+	fmt.Printf("Compressed %v from %d bytes to %d @ 0x%08X\n", r, original, len(compressed), address)
+	return writeMemory(mem, address, stored)
+}
+
+//lz4Block compresses data as a single LZ4 block, without the frame format
+func lz4Block(data []byte) []byte {
+	const (
+		minMatch     = 4
+		lastLiterals = 5  //The block always ends with at least this many literals
+		matchLimit   = 12 //A match can't start in the last 12 bytes
+		maxOffset    = 65535
+		hashBits     = 16
+	)
+	var out []byte
+	writeLength := func(length int) {
+		for ; length >= 255; length -= 255 {
+			out = append(out, 255)
+		}
+		out = append(out, byte(length))
+	}
+	emit := func(literals []byte, matchLength int, offset int) {
+		token := byte(0)
+		if len(literals) >= 15 {
+			token = 15 << 4
+		} else {
+			token = byte(len(literals)) << 4
+		}
+		if matchLength > 0 {
+			if matchLength-minMatch >= 15 {
+				token |= 15
+			} else {
+				token |= byte(matchLength - minMatch)
+			}
+		}
+		out = append(out, token)
+		if len(literals) >= 15 {
+			writeLength(len(literals) - 15)
+		}
+		out = append(out, literals...)
+		if matchLength > 0 {
+			out = append(out, byte(offset), byte(offset>>8))
+			if matchLength-minMatch >= 15 {
+				writeLength(matchLength - minMatch - 15)
+			}
+		}
+	}
+	table := make([]int, 1<<hashBits) //Last position + 1 of each 4 byte hash
+	hash := func(position int) uint32 {
+		return binary.LittleEndian.Uint32(data[position:]) * 2654435761 >> (32 - hashBits)
+	}
+	anchor := 0
+	for position := 0; position+matchLimit < len(data); {
+		h := hash(position)
+		candidate := table[h] - 1
+		table[h] = position + 1
+		if candidate < 0 || position-candidate > maxOffset ||
+			binary.LittleEndian.Uint32(data[candidate:]) != binary.LittleEndian.Uint32(data[position:]) {
+			position++
+			continue
+		}
+		length := minMatch
+		for position+length < len(data)-lastLiterals && data[candidate+length] == data[position+length] {
+			length++
+		}
+		emit(data[anchor:position], length, position-candidate)
+		position += length
+		anchor = position
+	}
+	emit(data[anchor:], 0, 0)
+	return out
+}
+
+//bitWriter packs values most significant bit first, as heatshrink reads them
+type bitWriter struct {
+	out   []byte
+	count uint
+}
+
+func (w *bitWriter) write(value uint32, bits uint) {
+	for bits > 0 {
+		bits--
+		if w.count%8 == 0 {
+			w.out = append(w.out, 0)
+		}
+		if value>>bits&1 != 0 {
+			w.out[len(w.out)-1] |= 0x80 >> (w.count % 8)
+		}
+		w.count++
+	}
+}
+
+//heatshrinkCompress encodes data as a heatshrink stream: a 1 bit then 8 bits for a literal,
+//or a 0 bit then offset-1 in window bits and length-1 in lookahead bits for a back reference
+func heatshrinkCompress(data []byte, window, lookahead int) []byte {
+	maxOffset := 1 << uint(window)
+	maxLength := 1 << uint(lookahead)
+	backrefBits := 1 + window + lookahead
+	//Chains of earlier positions sharing the same two bytes, newest first
+	head := make([]int, 1<<16)
+	previous := make([]int, len(data))
+	for i := range head {
+		head[i] = -1
+	}
+	key := func(position int) int { return int(data[position])<<8 | int(data[position+1]) }
+	insert := func(position int) {
+		if position+1 < len(data) {
+			previous[position] = head[key(position)]
+			head[key(position)] = position
+		}
+	}
+	w := &bitWriter{}
+	for position := 0; position < len(data); {
+		bestLength, bestOffset := 0, 0
+		if position+1 < len(data) {
+			depth := 0
+			for candidate := head[key(position)]; candidate >= 0 && position-candidate <= maxOffset && depth < 256; candidate = previous[candidate] {
+				depth++
+				length := 0
+				for length < maxLength && position+length < len(data) && data[candidate+length] == data[position+length] {
+					length++
+				}
+				if length > bestLength {
+					bestLength, bestOffset = length, position-candidate
+					if length == maxLength {
+						break
+					}
+				}
+			}
+		}
+		if bestLength*9 > backrefBits {
+			w.write(0, 1)
+			w.write(uint32(bestOffset-1), uint(window))
+			w.write(uint32(bestLength-1), uint(lookahead))
+		} else {
+			bestLength = 1
+			w.write(1, 1)
+			w.write(uint32(data[position]), 8)
+		}
+		for end := position + bestLength; position < end; position++ {
+			insert(position)
+		}
+	}
+	return w.out
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//compressTestData is a mix of repeated text, runs and noise like a real image
+func compressTestData() []byte {
+	var data []byte
+	for i := 0; i < 50; i++ {
+		data = append(data, fmt.Sprintf("entry %d of the vector table\n", i%7)...)
+	}
+	data = append(data, bytes.Repeat([]byte{0xFF}, 300)...)
+	seed := uint32(1)
+	for i := 0; i < 500; i++ {
+		seed = seed*1103515245 + 12345
+		data = append(data, byte(seed>>16))
+	}
+	return append(data, "trailing text"...)
+}
+
+//lz4Decode is a minimal LZ4 block decoder to check the output against
+func lz4Decode(block []byte) ([]byte, error) {
+	var out []byte
+	readLength := func(position *int, length int) int {
+		if length == 15 {
+			for {
+				b := block[*position]
+				*position++
+				length += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		return length
+	}
+	for position := 0; position < len(block); {
+		token := block[position]
+		position++
+		literals := readLength(&position, int(token>>4))
+		out = append(out, block[position:position+literals]...)
+		position += literals
+		if position == len(block) {
+			break
+		}
+		offset := int(binary.LittleEndian.Uint16(block[position:]))
+		position += 2
+		length := readLength(&position, int(token&15)) + 4
+		if offset == 0 || offset > len(out) {
+			return nil, fmt.Errorf("bad offset %d", offset)
+		}
+		for i := 0; i < length; i++ {
+			out = append(out, out[len(out)-offset])
+		}
+	}
+	return out, nil
+}
+
+//heatshrinkDecode reads the stream the same way as the heatshrink decoder
+func heatshrinkDecode(stream []byte, window, lookahead uint) []byte {
+	var out []byte
+	bit := uint(0)
+	read := func(count uint) (uint32, bool) {
+		if bit+count > uint(len(stream))*8 {
+			return 0, false
+		}
+		value := uint32(0)
+		for ; count > 0; count-- {
+			value = value<<1 | uint32(stream[bit/8]>>(7-bit%8)&1)
+			bit++
+		}
+		return value, true
+	}
+	for {
+		tag, ok := read(1)
+		if !ok {
+			return out
+		}
+		if tag == 1 {
+			b, ok := read(8)
+			if !ok {
+				return out
+			}
+			out = append(out, byte(b))
+			continue
+		}
+		index, ok := read(window)
+		if !ok {
+			return out
+		}
+		count, ok := read(lookahead)
+		if !ok {
+			return out
+		}
+		for i := uint32(0); i <= count; i++ {
+			out = append(out, out[len(out)-int(index)-1])
+		}
+	}
+}
+
+func TestCompressors(t *testing.T) {
+	t.Parallel()
+	data := compressTestData()
+	for _, algorithm := range []string{"deflate", "zlib", "lz4", "heatshrink", "heatshrink-11-4", "lzma"} {
+		t.Run(algorithm, func(t *testing.T) {
+			compress, err := newCompressor(algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed, err := compress(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(compressed) >= len(data) {
+				t.Errorf("%d bytes compressed to %d", len(data), len(compressed))
+			}
+			var decoded []byte
+			switch algorithm {
+			case "deflate":
+				decoded, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+			case "zlib":
+				r, zerr := zlib.NewReader(bytes.NewReader(compressed))
+				if zerr != nil {
+					t.Fatal(zerr)
+				}
+				decoded, err = ioutil.ReadAll(r)
+			case "lz4":
+				decoded, err = lz4Decode(compressed)
+			case "heatshrink":
+				decoded = heatshrinkDecode(compressed, 8, 4)
+			case "heatshrink-11-4":
+				decoded = heatshrinkDecode(compressed, 11, 4)
+			default:
+				return //lzma is checked against xz in lzma_test.go
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("Round trip does not match")
+			}
+		})
+	}
+	for _, algorithm := range []string{"lzo", "heatshrink-3-2", "heatshrink-8-8", "heatshrink-8"} {
+		if _, err := newCompressor(algorithm); err == nil {
+			t.Errorf("Should raise error on %s", algorithm)
+		}
+	}
+}
+
+func TestLz4ShortInputs(t *testing.T) {
+	t.Parallel()
+	for _, data := range [][]byte{{}, {1}, bytes.Repeat([]byte{7}, 12), bytes.Repeat([]byte{7}, 13), bytes.Repeat([]byte{7}, 1000)} {
+		decoded, err := lz4Decode(lz4Block(data))
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("%d bytes did not round trip, %v", len(data), err)
+		}
+	}
+}
+
+func TestCompressOption(t *testing.T) {
+	t.Parallel()
+	data := compressTestData()
+	settings := &compressSettings{fill: 0xFF}
+	compress, err := parseCompressOption("lz4:*:@0x9000", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x1000, data)
+	if err := compress.apply(mem); err != nil {
+		t.Fatal(err)
+	}
+	segments := mem.GetDataSegments()
+	if len(segments) != 1 || segments[0].Address != 0x9000 {
+		t.Fatalf("Range should be replaced by data @ 0x9000, got %d segments", len(segments))
+	}
+	stored := segments[0].Data
+	if int(binary.LittleEndian.Uint32(stored[0:])) != len(stored)-compressHeaderBytes || int(binary.LittleEndian.Uint32(stored[4:])) != len(data) {
+		t.Errorf("Bad size header %X", stored[:compressHeaderBytes])
+	}
+	if decoded, err := lz4Decode(stored[compressHeaderBytes:]); err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("Stored data does not decompress, %v", err)
+	}
+
+	//Storing in place, the range is removed first
+	mem = gohex.NewMemory()
+	mem.AddBinary(0x1000, data)
+	mem.AddBinary(0x3000, []byte{1, 2, 3})
+	compress, err = parseCompressOption("zlib:0x1000+0x1000:@0x1000", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compress.apply(mem); err != nil {
+		t.Fatal(err)
+	}
+	if segments := mem.GetDataSegments(); len(segments) != 2 || segments[0].Address != 0x1000 || segments[1].Address != 0x3000 {
+		t.Errorf("Expected compressed data @ 0x1000 and untouched data @ 0x3000")
+	}
+	//Overwriting other data fails before the range is removed
+	compress, err = parseCompressOption("zlib:0x1000+0x100:@0x2FFF", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := mem.GetDataSegments()
+	if err := compress.apply(mem); err == nil {
+		t.Error("Should raise error storing over data")
+	}
+	if !reflect.DeepEqual(mem.GetDataSegments(), before) {
+		t.Error("Image should not change when storing fails")
+	}
+
+	//Writing to a file leaves the image alone
+	file, err := os.CreateTemp("", "test_*.lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	mem = gohex.NewMemory()
+	mem.AddBinary(0x1000, data)
+	compress, err = parseCompressOption("lz4:*:"+file.Name(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compress.apply(mem); err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := lz4Decode(written); err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("File does not decompress, %v", err)
+	}
+	if segments := mem.GetDataSegments(); len(segments) != 1 || !reflect.DeepEqual(segments[0].Data, data) {
+		t.Errorf("Image should not change when writing to a file")
+	}
+
+	for _, value := range []string{"lz4:*", "lz4:*:", "zip:*:@0x100", "lz4:x:@0x100", "lz4:*:@zz"} {
+		if _, err := parseCompressOption(value, settings); err == nil {
+			t.Errorf("Should raise error on --compress=%s", value)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//Delta files are little endian:
+//	"HXMD", version byte, SHA-256 of the new image (see imageDigest), segment count u32
+//	then per segment: address u32, length u32, followed by operations until length bytes are produced
+//	deltaCopy: old address u32, length u32 | deltaInsert: length u32, data
+const (
+	deltaMagic   = "HXMD"
+	deltaVersion = 1
+	deltaCopy    = 0
+	deltaInsert  = 1
+	//Old image positions are indexed every deltaStep bytes by the deltaWindow bytes found there,
+	//which finds any match at least deltaWindow+deltaStep long
+	deltaWindow   = 8
+	deltaStep     = 8
+	deltaMinMatch = 16 //A copy costs 9 bytes, so shorter matches are inserted
+)
+
+//imageDigest hashes the address, length and data of every segment, so images match only if their layout matches too
+func imageDigest(mem *gohex.Memory) []byte {
+	h := sha256.New()
+	header := make([]byte, 8)
+	for _, segment := range mem.GetDataSegments() {
+		binary.LittleEndian.PutUint32(header[0:], segment.Address)
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(segment.Data)))
+		h.Write(header)
+		h.Write(segment.Data)
+	}
+	return h.Sum(nil)
+}
+
+//deltaSource gives access to the old image by address
+type deltaSource struct {
+	segments []gohex.DataSegment
+	index    map[uint64]uint32
+}
+
+func newDeltaSource(old *gohex.Memory) *deltaSource {
+	source := &deltaSource{segments: old.GetDataSegments(), index: map[uint64]uint32{}}
+	for _, segment := range source.segments {
+		for offset := 0; offset+deltaWindow <= len(segment.Data); offset += deltaStep {
+			key := binary.LittleEndian.Uint64(segment.Data[offset:])
+			if _, exists := source.index[key]; !exists {
+				source.index[key] = segment.Address + uint32(offset)
+			}
+		}
+	}
+	return source
+}
+
+//at returns the old data from the address to the end of its segment
+func (s *deltaSource) at(address uint32) []byte {
+	i := sort.Search(len(s.segments), func(i int) bool {
+		return uint64(s.segments[i].Address)+uint64(len(s.segments[i].Data)) > uint64(address)
+	})
+	if i == len(s.segments) || s.segments[i].Address > address {
+		return nil
+	}
+	return s.segments[i].Data[address-s.segments[i].Address:]
+}
+
+//match finds old data matching the start of data, returning its address and length
+func (s *deltaSource) match(data []byte) (uint32, int) {
+	if len(data) < deltaWindow {
+		return 0, 0
+	}
+	address, ok := s.index[binary.LittleEndian.Uint64(data)]
+	if !ok {
+		return 0, 0
+	}
+	old := s.at(address)
+	length := 0
+	for length < len(old) && length < len(data) && old[length] == data[length] {
+		length++
+	}
+	return address, length
+}
+
+//writeDelta encodes updated as copies from old and inserted data
+func writeDelta(writer io.Writer, old, updated *gohex.Memory) error {
+	source := newDeltaSource(old)
+	w := bufio.NewWriter(writer)
+	segments := updated.GetDataSegments()
+	w.WriteString(deltaMagic)
+	w.WriteByte(deltaVersion)
+	w.Write(imageDigest(updated))
+	binary.Write(w, binary.LittleEndian, uint32(len(segments)))
+	for _, segment := range segments {
+		binary.Write(w, binary.LittleEndian, []uint32{segment.Address, uint32(len(segment.Data))})
+		data := segment.Data
+		pending := 0 //Start of data not yet covered by an operation
+		for position := 0; position < len(data); {
+			address, length := source.match(data[position:])
+			if length < deltaMinMatch {
+				position++
+				continue
+			}
+			if position > pending {
+				binary.Write(w, binary.LittleEndian, uint8(deltaInsert))
+				binary.Write(w, binary.LittleEndian, uint32(position-pending))
+				w.Write(data[pending:position])
+			}
+			binary.Write(w, binary.LittleEndian, uint8(deltaCopy))
+			binary.Write(w, binary.LittleEndian, []uint32{address, uint32(length)})
+			position += length
+			pending = position
+		}
+		if len(data) > pending {
+			binary.Write(w, binary.LittleEndian, uint8(deltaInsert))
+			binary.Write(w, binary.LittleEndian, uint32(len(data)-pending))
+			w.Write(data[pending:])
+		}
+	}
+	return w.Flush()
+}
+
+//applyDelta rebuilds the new image from old and the delta, checking the result against the digest in the delta
+func applyDelta(old *gohex.Memory, reader io.Reader) (*gohex.Memory, error) {
+	r := bufio.NewReader(reader)
+	header := make([]byte, len(deltaMagic)+1+sha256.Size)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("delta header => %v", err)
+	}
+	if string(header[:len(deltaMagic)]) != deltaMagic || header[len(deltaMagic)] != deltaVersion {
+		return nil, fmt.Errorf("not a version %d hexm delta", deltaVersion)
+	}
+	digest := header[len(deltaMagic)+1:]
+	source := &deltaSource{segments: old.GetDataSegments()}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	patched := gohex.NewMemory()
+	for i := uint32(0); i < count; i++ {
+		var segment [2]uint32
+		if err := binary.Read(r, binary.LittleEndian, &segment); err != nil {
+			return nil, err
+		}
+		//Lengths come from the delta, so the segment only grows as copies and inserted data actually arrive
+		data := bytes.Buffer{}
+		for uint32(data.Len()) < segment[1] {
+			op, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			switch op {
+			case deltaCopy:
+				var copyOp [2]uint32
+				if err := binary.Read(r, binary.LittleEndian, &copyOp); err != nil {
+					return nil, err
+				}
+				if copyOp[1] > segment[1]-uint32(data.Len()) {
+					return nil, fmt.Errorf("delta copies past the end of the segment @ 0x%08X", segment[0])
+				}
+				oldData := source.at(copyOp[0])
+				if uint32(len(oldData)) < copyOp[1] {
+					return nil, fmt.Errorf("delta copies %d bytes from 0x%08X which the old image doesn't have", copyOp[1], copyOp[0])
+				}
+				data.Write(oldData[:copyOp[1]])
+			case deltaInsert:
+				var length uint32
+				if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+					return nil, err
+				}
+				if length > segment[1]-uint32(data.Len()) {
+					return nil, fmt.Errorf("delta inserts past the end of the segment @ 0x%08X", segment[0])
+				}
+				if _, err := io.CopyN(&data, r, int64(length)); err != nil {
+					return nil, fmt.Errorf("delta insert @ 0x%08X => %v", segment[0], err)
+				}
+			default:
+				return nil, fmt.Errorf("unknown delta operation %d", op)
+			}
+		}
+		if err := patched.AddBinary(segment[0], data.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(imageDigest(patched), digest) {
+		return nil, fmt.Errorf("rebuilt image does not match the delta's SHA-256, was it made from a different old image?")
+	}
+	return patched, nil
+}
+
+//runDelta implements `hexm delta old new out.delta`
+func runDelta(args []string) error {
+	options, files := splitOptions(args)
+	if len(options) > 0 {
+		return fmt.Errorf("unknown option --%s", options[0].name)
+	}
+	if len(files) != 3 {
+		return fmt.Errorf("delta takes an old image, a new image and the delta file to write")
+	}
+	for _, input := range files[:2] {
+		if err := validateFile(input, true); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(files[2]); err == nil && !userConfirm(fmt.Sprintf("Overwrite %s?", files[2])) {
+		return fmt.Errorf("not overwriting %s", files[2])
+	}
+	old, err := parseInputFile(files[0])
+	if err != nil {
+		return err
+	}
+	updated, err := parseInputFile(files[1])
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(files[2])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := writeDelta(file, old, updated); err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d byte delta to %s\n", info.Size(), files[2])
+	return nil
+}
+
+//runUndelta implements `hexm undelta old in.delta out`, the output can be any format hexm writes
+func runUndelta(args []string) error {
+	options, files := splitOptions(args)
+	if len(options) > 0 {
+		return fmt.Errorf("unknown option --%s", options[0].name)
+	}
+	if len(files) != 3 {
+		return fmt.Errorf("undelta takes an old image, a delta file and the image to write")
+	}
+	if err := validateFiles(files[:1], files[2]); err != nil {
+		return err
+	}
+	old, err := parseInputFile(files[0])
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(files[1])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	patched, err := applyDelta(old, file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rebuilt image matches the delta's SHA-256 %x\n", imageDigest(patched))
+	return writeOutput(files[2], memoryImage{patched}, outputOptions{})
+}
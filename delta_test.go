@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	t.Parallel()
+	firmware := make([]byte, 4096)
+	for i := range firmware {
+		firmware[i] = byte(i * 7 / 3)
+	}
+	old := gohex.NewMemory()
+	old.AddBinary(0x08000000, firmware)
+	old.AddBinary(0x08010000, []byte("config v1"))
+
+	//Insert a few bytes, patch some others and move the config
+	changed := append(append(append([]byte{}, firmware[:1000]...), 0xDE, 0xAD), firmware[1000:]...)
+	changed[3000] ^= 0xFF
+	updated := gohex.NewMemory()
+	updated.AddBinary(0x08000000, changed)
+	updated.AddBinary(0x08020000, []byte("config v2"))
+
+	var delta bytes.Buffer
+	if err := writeDelta(&delta, old, updated); err != nil {
+		t.Fatal(err)
+	}
+	if delta.Len() > 200 {
+		t.Errorf("delta is %d bytes, should mostly be copies", delta.Len())
+	}
+	rebuilt, err := applyDelta(old, bytes.NewReader(delta.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rebuilt.GetDataSegments(), updated.GetDataSegments()) {
+		t.Errorf("rebuilt image does not match")
+	}
+
+	//Applying against the wrong old image fails the hash check or the copy
+	wrong := gohex.NewMemory()
+	wrong.AddBinary(0x08000000, bytes.Repeat([]byte{0xFF}, 4096))
+	if _, err := applyDelta(wrong, bytes.NewReader(delta.Bytes())); err == nil {
+		t.Errorf("Should raise error applying to a different old image")
+	}
+	empty := gohex.NewMemory()
+	if _, err := applyDelta(empty, bytes.NewReader(delta.Bytes())); err == nil {
+		t.Errorf("Should raise error copying from missing data")
+	}
+	if _, err := applyDelta(old, bytes.NewReader(delta.Bytes()[:delta.Len()-3])); err == nil {
+		t.Errorf("Should raise error on a truncated delta")
+	}
+	if _, err := applyDelta(old, bytes.NewReader([]byte("HXMP"))); err == nil {
+		t.Errorf("Should raise error on a bad header")
+	}
+	//Lengths claimed by a corrupt delta are only trusted as far as the data really goes
+	hostile := bytes.Buffer{}
+	hostile.WriteString(deltaMagic)
+	hostile.WriteByte(deltaVersion)
+	hostile.Write(make([]byte, 32))
+	binary.Write(&hostile, binary.LittleEndian, []uint32{1, 0x08000000, 0xFFFFFFFF})
+	hostile.WriteByte(deltaInsert)
+	binary.Write(&hostile, binary.LittleEndian, uint32(0xFFFFFF00))
+	hostile.WriteString("data")
+	if _, err := applyDelta(old, &hostile); err == nil {
+		t.Errorf("Should raise error on a delta shorter than its segment")
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+	t.Parallel()
+	a := gohex.NewMemory()
+	a.AddBinary(0x1000, []byte{1, 2, 3})
+	b := gohex.NewMemory()
+	b.AddBinary(0x1001, []byte{1, 2, 3})
+	if bytes.Equal(imageDigest(a), imageDigest(b)) {
+		t.Errorf("Images at different addresses should not share a digest")
+	}
+}
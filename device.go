@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+//jsonNumber lets JSON files give numbers as strings such as "0x08000000" as well as plain numbers
+type jsonNumber uint32
+
+func (n *jsonNumber) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	value, err := parseNumberString(text)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", data)
+	}
+	*n = jsonNumber(value)
+	return nil
+}
+
+func (n jsonNumber) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("0x%08X", uint32(n)))
+}
+
+//memoryRegion is one block of a device's address space
+type memoryRegion struct {
+	Name        string     `json:"name"`
+	Start       jsonNumber `json:"start"`
+	Size        jsonNumber `json:"size"`
+	Type        string     `json:"type"`        //flash, ram, eeprom or otp
+	Granularity jsonNumber `json:"granularity"` //Smallest unit that can be written, 0 or 1 for any
+}
+
+//device is a named memory map
+type device struct {
+	Name    string         `json:"name"`
+	Regions []memoryRegion `json:"regions"`
+}
+
+//builtinDevices covers a few common parts, anything else can be loaded from a JSON file
+var builtinDevices = map[string]device{
+	"stm32f103c8": {Name: "stm32f103c8", Regions: []memoryRegion{
+		{Name: "flash", Start: 0x08000000, Size: 64 * 1024, Type: "flash", Granularity: 2},
+		{Name: "option bytes", Start: 0x1FFFF800, Size: 16, Type: "otp", Granularity: 2},
+		{Name: "sram", Start: 0x20000000, Size: 20 * 1024, Type: "ram"},
+	}},
+	"stm32f407vg": {Name: "stm32f407vg", Regions: []memoryRegion{
+		{Name: "flash", Start: 0x08000000, Size: 1024 * 1024, Type: "flash", Granularity: 1},
+		{Name: "ccm", Start: 0x10000000, Size: 64 * 1024, Type: "ram"},
+		{Name: "otp", Start: 0x1FFF7800, Size: 528, Type: "otp", Granularity: 1},
+		{Name: "sram", Start: 0x20000000, Size: 128 * 1024, Type: "ram"},
+	}},
+	"nrf52840": {Name: "nrf52840", Regions: []memoryRegion{
+		{Name: "flash", Start: 0x00000000, Size: 1024 * 1024, Type: "flash", Granularity: 4},
+		{Name: "uicr", Start: 0x10001000, Size: 0x310, Type: "otp", Granularity: 4},
+		{Name: "ram", Start: 0x20000000, Size: 256 * 1024, Type: "ram"},
+	}},
+	"rp2040": {Name: "rp2040", Regions: []memoryRegion{
+		{Name: "xip flash", Start: 0x10000000, Size: 16 * 1024 * 1024, Type: "flash", Granularity: 256},
+		{Name: "sram", Start: 0x20000000, Size: 264 * 1024, Type: "ram"},
+	}},
+	"atmega328p": {Name: "atmega328p", Regions: []memoryRegion{
+		{Name: "flash", Start: 0x0000, Size: 32 * 1024, Type: "flash", Granularity: 2},
+	}},
+}
+
+//regionTypes maps each region type to whether a programmer can write it
+var regionTypes = map[string]bool{
+	"flash":  true,
+	"eeprom": true,
+	"otp":    true,
+	"ram":    false,
+}
+
+//loadDevice returns a built in device by name, or loads one from a JSON file
+func loadDevice(nameOrPath string) (device, error) {
+	if d, ok := builtinDevices[strings.ToLower(nameOrPath)]; ok {
+		return d, nil
+	}
+	if _, err := os.Stat(nameOrPath); err != nil {
+		names := []string{}
+		for name := range builtinDevices {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return device{}, fmt.Errorf("unknown device %s, should be a JSON file or one of %v", nameOrPath, names)
+	}
+	data, err := ioutil.ReadFile(nameOrPath)
+	if err != nil {
+		return device{}, err
+	}
+	d := device{}
+	if err := json.Unmarshal(data, &d); err != nil {
+		return device{}, fmt.Errorf("could not parse device %s => %v", nameOrPath, err)
+	}
+	if d.Name == "" {
+		d.Name = nameOrPath
+	}
+	for _, region := range d.Regions {
+		if _, ok := regionTypes[region.Type]; !ok {
+			return device{}, fmt.Errorf("region %s of %s has unknown type %q", region.Name, nameOrPath, region.Type)
+		}
+		if uint64(region.Start)+uint64(region.Size) > 1<<32 || region.Size == 0 {
+			return device{}, fmt.Errorf("region %s of %s is empty or outside of the address space", region.Name, nameOrPath)
+		}
+	}
+	return d, nil
+}
+
+//checkMemoryMap lists every way the image doesn't fit the device
+//Each segment has to sit entirely inside writable regions, and start and end on the region's write granularity
+func checkMemoryMap(img image, d device) []string {
+	problems := []string{}
+	regions := append([]memoryRegion{}, d.Regions...)
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Start < regions[j].Start })
+	for _, segmentRange := range img.ranges() {
+		cursor := segmentRange.start
+		for _, region := range regions {
+			r := addressRange{start: uint64(region.Start), end: uint64(region.Start) + uint64(region.Size)}
+			start, end := cursor, segmentRange.end
+			if r.end <= start || r.start >= end {
+				continue
+			}
+			if r.start > start {
+				problems = append(problems, fmt.Sprintf("0x%08X-0x%08X is outside of any region of %s", start, r.start, d.Name))
+				start = r.start
+			}
+			if r.end < end {
+				end = r.end
+			}
+			if !regionTypes[region.Type] {
+				problems = append(problems, fmt.Sprintf("0x%08X-0x%08X is in %s region %s which can't be programmed", start, end, region.Type, region.Name))
+			} else if region.Granularity > 1 {
+				granularity := uint64(region.Granularity)
+				//Only the segment's own ends matter, a segment running across a region boundary is checked by each region
+				if (start == segmentRange.start && start%granularity != 0) || (end == segmentRange.end && end%granularity != 0) {
+					problems = append(problems, fmt.Sprintf("0x%08X-0x%08X in %s is not aligned to its %d byte write size", start, end, region.Name, region.Granularity))
+				}
+			}
+			cursor = end
+		}
+		if cursor < segmentRange.end {
+			problems = append(problems, fmt.Sprintf("0x%08X-0x%08X is outside of any region of %s", cursor, segmentRange.end, d.Name))
+		}
+	}
+	return problems
+}
+
+//reportMemoryMap prints every problem found by checkMemoryMap, raising an error if there were any
+func reportMemoryMap(img image, d device) error {
+	problems := checkMemoryMap(img, d)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("image does not fit the memory map of %s", d.Name)
+	}
+	fmt.Printf("Image fits the memory map of %s\n", d.Name)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestLoadDevice(t *testing.T) {
+	t.Parallel()
+	d, err := loadDevice("STM32F103C8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "stm32f103c8" {
+		t.Errorf("got %v", d.Name)
+	}
+	tmpfile, err := os.CreateTemp("", "*_device.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString(`{"name": "custom", "regions": [
+		{"name": "boot", "start": "0x08000000", "size": "0x4000", "type": "flash", "granularity": 8},
+		{"name": "data", "start": 134234112, "size": 1024, "type": "eeprom"}
+	]}`)
+	tmpfile.Close()
+	d, err = loadDevice(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := device{Name: "custom", Regions: []memoryRegion{
+		{Name: "boot", Start: 0x08000000, Size: 0x4000, Type: "flash", Granularity: 8},
+		{Name: "data", Start: 0x08004000, Size: 1024, Type: "eeprom"},
+	}}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("got %v, want %v", d, want)
+	}
+	if _, err := loadDevice("not-a-device"); err == nil {
+		t.Error("Should raise error on unknown device")
+	}
+}
+
+func TestLoadDeviceErrors(t *testing.T) {
+	t.Parallel()
+	for _, content := range []string{
+		`{"regions": [{"name": "a", "start": 0, "size": 16, "type": "rom"}]}`,
+		`{"regions": [{"name": "a", "start": "0xFFFFFFF0", "size": 32, "type": "flash"}]}`,
+		`{"regions": [{"name": "a", "start": "0xZZ", "size": 32, "type": "flash"}]}`,
+		`{"regions": [`,
+	} {
+		tmpfile, err := os.CreateTemp("", "*_device.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpfile.Name())
+		tmpfile.WriteString(content)
+		tmpfile.Close()
+		if _, err := loadDevice(tmpfile.Name()); err == nil {
+			t.Errorf("Should raise error loading %s", content)
+		}
+	}
+}
+
+func TestCheckMemoryMap(t *testing.T) {
+	t.Parallel()
+	d := device{Name: "test", Regions: []memoryRegion{
+		{Name: "flash", Start: 0x1000, Size: 0x1000, Type: "flash", Granularity: 4},
+		{Name: "eeprom", Start: 0x2000, Size: 0x100, Type: "eeprom"},
+		{Name: "ram", Start: 0x4000, Size: 0x1000, Type: "ram"},
+	}}
+	var tests = []struct {
+		name    string
+		address uint32
+		length  int
+		want    []string
+	}{
+		{"inside", 0x1000, 0x100, []string{}},
+		{"across regions", 0x1F00, 0x200, []string{}},
+		{"unaligned", 0x1002, 0x4, []string{"0x00001002-0x00001006 in flash is not aligned to its 4 byte write size"}},
+		{"past end", 0x2080, 0x100, []string{"0x00002100-0x00002180 is outside of any region of test"}},
+		{"before", 0x0F00, 0x200, []string{"0x00000F00-0x00001000 is outside of any region of test"}},
+		{"ram", 0x4000, 0x10, []string{"0x00004000-0x00004010 is in ram region ram which can't be programmed"}},
+		{"gap", 0x20F0, 0x2000, []string{
+			"0x00002100-0x00004000 is outside of any region of test",
+			"0x00004000-0x000040F0 is in ram region ram which can't be programmed",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := gohex.NewMemory()
+			mem.AddBinary(tt.address, make([]byte, tt.length))
+			problems := checkMemoryMap(memoryImage{mem}, d)
+			if !reflect.DeepEqual(problems, tt.want) {
+				t.Errorf("got %v, want %v", problems, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+//digestSettings are shared by every --digest, so --digest-fill applies wherever it is given
+type digestSettings struct {
+	fill byte
+}
+
+//newDigest looks up a hash algorithm by name
+func newDigest(algorithm string) (hash.Hash, error) {
+	newHash, ok := digestAlgorithms[strings.ToLower(algorithm)]
+	if !ok {
+		names := []string{}
+		for name := range digestAlgorithms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown digest %s, should be one of %v", algorithm, names)
+	}
+	return newHash(), nil
+}
+
+//digestMemory hashes the range, gaps are hashed as the fill byte so the digest matches what gets programmed
+func digestMemory(mem *gohex.Memory, r addressRange, fill byte, algorithm string) ([]byte, error) {
+	h, err := newDigest(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	//Hash a chunk at a time so the whole range never has to be in one buffer
+	const chunk = 1024 * 1024
+	for start := r.start; start < r.end; start += chunk {
+		end := start + chunk
+		if end > r.end {
+			end = r.end
+		}
+		h.Write(readMemory(mem, addressRange{start: start, end: end}, fill))
+	}
+	return h.Sum(nil), nil
+}
+
+//parseDigestOption parses ALGORITHM:RANGE[@ADDRESS], when an address is given the digest is also written there
+func parseDigestOption(value string, settings *digestSettings) (transform, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if _, err := newDigest(parts[0]); err != nil {
+		return transform{}, err
+	}
+	rangeText := ""
+	if len(parts) == 2 {
+		rangeText = parts[1]
+	}
+	var address uint32
+	store := false
+	if index := strings.Index(rangeText, "@"); index >= 0 {
+		n, err := parseNumberString(rangeText[index+1:])
+		if err != nil {
+			return transform{}, err
+		}
+		address = n
+		store = true
+		rangeText = rangeText[:index]
+	}
+	r, err := parseAddressRange(rangeText)
+	if err != nil {
+		return transform{}, err
+	}
+	algorithm := parts[0]
+	return transform{
+		name: fmt.Sprintf("%s digest of %v", algorithm, r),
+		apply: func(mem *gohex.Memory) error {
+			hashed := imageRange(mem, r)
+			digest, err := digestMemory(mem, hashed, settings.fill, algorithm)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s %v %x\n", algorithm, hashed, digest)
+			if !store {
+				return nil
+			}
+			return storeDigest(mem, hashed, address, digest, settings.fill)
+		},
+	}, nil
+}
+
+//storeDigest writes the digest into the image, it can't land inside the range it covers or on top of other data
+func storeDigest(mem *gohex.Memory, hashed addressRange, address uint32, digest []byte, fill byte) error {
+	target := addressRange{start: uint64(address), end: uint64(address) + uint64(len(digest))}
+	if target.end > 1<<32 {
+		return fmt.Errorf("digest @ 0x%08X runs past the end of the address space", address)
+	}
+	if target.start < hashed.end && hashed.start < target.end {
+		return fmt.Errorf("digest @ 0x%08X would change the range %v it covers", address, hashed)
+	}
+	if existing, b, found := findData(mem, target, fill); found {
+		return fmt.Errorf("digest would overwrite data 0x%02X @ 0x%08X", b, existing)
+	}
+	mem.SetBinary(address, digest)
+	return nil
+}
+
+//runHash implements `hexm hash file[:range]...`, printing digests of each file
+func runHash(args []string) error {
+	options, files := splitOptions(args)
+	algorithms := []string{"sha256"}
+	fill := byte(0xFF)
+	for _, opt := range options {
+		switch opt.name {
+		case "alg":
+			algorithms = strings.Split(opt.value, ",")
+			for _, algorithm := range algorithms {
+				if _, err := newDigest(algorithm); err != nil {
+					return err
+				}
+			}
+		case "fill":
+			value, err := parseByte(opt.value)
+			if err != nil {
+				return fmt.Errorf("invalid --fill=%s => %v", opt.value, err)
+			}
+			fill = value
+		default:
+			return fmt.Errorf("unknown option --%s", opt.name)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no file to hash")
+	}
+	for _, file := range files {
+		path, r, err := splitPathRange(file)
+		if err != nil {
+			return err
+		}
+		if err := validateFile(path, true); err != nil {
+			return err
+		}
+		mem, err := parseInputFile(path)
+		if err != nil {
+			return err
+		}
+		hashed := imageRange(mem, r)
+		for _, algorithm := range algorithms {
+			digest, err := digestMemory(mem, hashed, fill, algorithm)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s %s %v %x\n", algorithm, path, hashed, digest)
+		}
+	}
+	return nil
+}
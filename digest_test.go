@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestDigestMemory(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x100, []byte{1, 2})
+	mem.AddBinary(0x104, []byte{3})
+	programmed := []byte{1, 2, 0xFF, 0xFF, 3}
+	sha256Digest := sha256.Sum256(programmed)
+	sha1Digest := sha1.Sum(programmed)
+	md5Digest := md5.Sum(programmed)
+	var tests = []struct {
+		algorithm string
+		want      []byte
+	}{
+		{"sha256", sha256Digest[:]},
+		{"SHA1", sha1Digest[:]},
+		{"md5", md5Digest[:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			digest, err := digestMemory(mem, imageRange(mem, fullAddressRange), 0xFF, tt.algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(digest, tt.want) {
+				t.Errorf("got %x, want %x", digest, tt.want)
+			}
+		})
+	}
+	_, err := digestMemory(mem, fullAddressRange, 0xFF, "crc32")
+	if err == nil {
+		t.Error("Should raise error on unknown algorithm")
+	}
+}
+
+func TestDigestOption(t *testing.T) {
+	t.Parallel()
+	settings := &digestSettings{fill: 0xFF}
+	digest, err := parseDigestOption("md5:0x100+4@0x200", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x100, []byte{1, 2, 3, 4})
+	if err := digest.apply(mem); err != nil {
+		t.Fatal(err)
+	}
+	want := md5.Sum([]byte{1, 2, 3, 4})
+	segments := mem.GetDataSegments()
+	if len(segments) != 2 || segments[1].Address != 0x200 || !reflect.DeepEqual(segments[1].Data, want[:]) {
+		t.Errorf("Digest should be stored @ 0x200, got %v", segments)
+	}
+	//Storing inside the range would change the digest
+	digest, err = parseDigestOption("md5:0x100+4@0x102", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := digest.apply(mem); err == nil {
+		t.Error("Should raise error storing a digest inside its range")
+	}
+	//Storing over existing data
+	digest, err = parseDigestOption("md5:0x100+4@0x204", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := digest.apply(mem); err == nil {
+		t.Error("Should raise error storing a digest over data")
+	}
+	for _, value := range []string{"crc:*", "md5:0x100", "md5:*@x"} {
+		if _, err := parseDigestOption(value, settings); err == nil {
+			t.Errorf("Should raise error on --digest=%s", value)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//dryRunConfirm answers overlap prompts during --dry-run with the prompt's default of yes
+func dryRunConfirm(segment addressRange, source string) bool {
+	fmt.Printf("Would ask to overwrite existing data with the segment @ 0x%08X from %s, assuming yes\n", segment.start, source)
+	return true
+}
+
+//checkDryRunFiles is validateFiles without the overwrite prompt
+func checkDryRunFiles(inputs []string, output string) error {
+	for _, input := range inputs {
+		if err := validateFile(input, true); err != nil {
+			return err
+		}
+	}
+	_, _, path, err := parseFileTypeAndStart(output)
+	if err != nil {
+		return fmt.Errorf("invalid file format %s => %v", path, err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("Would ask to overwrite %s\n", path)
+	}
+	return nil
+}
+
+//printMemoryMap lists every segment of the image with what the symbols say is there
+func printMemoryMap(img image, symbols *symbolTable) {
+	segments := img.ranges()
+	total := uint64(0)
+	fmt.Printf("Memory map, %d segments:\n", len(segments))
+	for _, r := range segments {
+		line := fmt.Sprintf("  %v ; len %d", r, r.end-r.start)
+		if owners := symbols.describeRange(r); owners != "" {
+			line += " ; " + owners
+		}
+		fmt.Println(line)
+		total += r.end - r.start
+	}
+	fmt.Printf("  %d bytes of data\n", total)
+}
+
+//countingWriter counts what would have been written
+type countingWriter struct {
+	count uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.count += uint64(len(p))
+	return len(p), nil
+}
+
+//describeOutput says what writeOutput would write for the image, without writing it
+func describeOutput(outputFile string, img image, opts outputOptions) (string, error) {
+	outputHex, binaryStart, path, err := parseFileTypeAndStart(outputFile)
+	if err != nil {
+		return "", err
+	}
+	segments := img.ranges()
+	data := uint64(0)
+	for _, segment := range segments {
+		data += segment.end - segment.start
+	}
+	if err := checkOutputOptions(outputHex, path, opts); err != nil {
+		return "", err
+	}
+	if isSegmentIndex(path) {
+		for _, segment := range segments {
+			segmentPath := filepath.Join(filepath.Dir(path), segmentFileName(path, uint32(segment.start)))
+			if _, err := os.Stat(segmentPath); err == nil {
+				fmt.Printf("Would ask to overwrite %s\n", segmentPath)
+			}
+		}
+		return fmt.Sprintf("%s with %d segment files holding %d bytes", path, len(segments), data), nil
+	}
+	if outputHex {
+		counter := &countingWriter{}
+		switch {
+		case opts.layout != "":
+			template, err := ioutil.ReadFile(opts.layout)
+			if err != nil {
+				return "", err
+			}
+			mem, err := toMemory(img)
+			if err != nil {
+				return "", err
+			}
+			err = writeHexPreserving(counter, mem, bytes.NewReader(template), opts.layout)
+		case opts.canonical > 0:
+			err = writeCanonicalHex(counter, img, opts.canonical)
+		default:
+			err = writeHex(counter, img)
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s of %d bytes holding %d bytes of data", path, counter.count, data), nil
+	}
+	_, length, err := binaryLength(img, binaryStart, opts)
+	if err != nil {
+		return "", err
+	}
+	fileRange := addressRange{start: uint64(binaryStart), end: uint64(binaryStart) + length}
+	padding := uint64(0)
+	for _, gap := range imageGaps(img, fileRange) {
+		padding += gap.end - gap.start
+	}
+	description := fmt.Sprintf("%s of %d bytes, %d of them padding", path, length, padding)
+	if padding > 128*1024*1024 {
+		description += ", would ask before writing that much padding"
+	}
+	return description, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestDescribeOutput(t *testing.T) {
+	t.Parallel()
+	dir, err := os.MkdirTemp("", "dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x1000, make([]byte, 0x20))
+	mem.AddBinary(0x1040, make([]byte, 0x10))
+	hexPath := filepath.Join(dir, "out.hex")
+	want := bytes.Buffer{}
+	if err := writeHex(&want, memoryImage{mem}); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		output string
+		opts   outputOptions
+		want   string
+	}{
+		{hexPath, outputOptions{}, hexPath + " of " + strconv.Itoa(want.Len()) + " bytes holding 48 bytes of data"},
+		{filepath.Join(dir, "out.bin:0x1000"), outputOptions{}, filepath.Join(dir, "out.bin") + " of 80 bytes, 32 of them padding"},
+		{filepath.Join(dir, "out.bin:0x1000"), outputOptions{padTo: 0x100}, filepath.Join(dir, "out.bin") + " of 256 bytes, 208 of them padding"},
+		{filepath.Join(dir, "out.json"), outputOptions{}, filepath.Join(dir, "out.json") + " with 2 segment files holding 48 bytes"},
+	}
+	for _, tt := range tests {
+		got, err := describeOutput(tt.output, memoryImage{mem}, tt.opts)
+		if err != nil || got != tt.want {
+			t.Errorf("%s got %q %v, want %q", tt.output, got, err, tt.want)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Describing outputs should not create files, found %v", entries)
+	}
+}
+
+func TestMergeSegmentsConfirm(t *testing.T) {
+	t.Parallel()
+	base := gohex.NewMemory()
+	base.AddBinary(0x100, []byte{1, 2, 3, 4})
+	additional := gohex.NewMemory()
+	additional.AddBinary(0x102, []byte{5, 6, 7})
+	asked := []string{}
+	mergeSegments(memoryImage{base}, memoryImage{additional}, "second.hex", nil, func(segment addressRange, source string) bool {
+		asked = append(asked, source)
+		return true
+	})
+	if len(asked) != 1 || !strings.Contains(asked[0], "second.hex") {
+		t.Errorf("Confirm should be asked once about second.hex, got %v", asked)
+	}
+	segments := base.GetDataSegments()
+	if len(segments) != 1 || !bytes.Equal(segments[0].Data, []byte{1, 2, 5, 6, 7}) {
+		t.Errorf("got %v", segments)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+const dumpLineLength = 16
+
+//runDump implements `hexm dump file[:range]`, printing a hexdump of the file using real addresses
+func runDump(args []string) error {
+	options, files := splitOptions(args)
+	wordBytes := 1
+	bigEndian := false
+	var symbols *symbolTable
+	for _, opt := range options {
+		switch opt.name {
+		case "symbols":
+			table, err := loadSymbols(opt.value)
+			if err != nil {
+				return err
+			}
+			if symbols == nil {
+				symbols = table
+			} else {
+				symbols.merge(table)
+			}
+		case "width":
+			bits, err := parseNumberString(opt.value)
+			if err != nil || (bits != 8 && bits != 16 && bits != 32) {
+				return fmt.Errorf("invalid --width=%s, should be 8, 16 or 32", opt.value)
+			}
+			wordBytes = int(bits / 8)
+		case "endian":
+			switch opt.value {
+			case "little":
+				bigEndian = false
+			case "big":
+				bigEndian = true
+			default:
+				return fmt.Errorf("invalid --endian=%s, should be little or big", opt.value)
+			}
+		default:
+			return fmt.Errorf("unknown option --%s", opt.name)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no file to dump")
+	}
+	for _, file := range files {
+		path, r, err := splitPathRange(file)
+		if err != nil {
+			return err
+		}
+		if err := validateFile(path, true); err != nil {
+			return err
+		}
+		mem, err := parseInputFile(path)
+		if err != nil {
+			return err
+		}
+		if len(files) > 1 {
+			fmt.Printf("%s:\n", file)
+		}
+		if err := dumpMemory(os.Stdout, mem, r, wordBytes, bigEndian, symbols); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//splitPathRange pulls an optional trailing :RANGE off a file path
+//As bin files already use :start, the suffix is only taken as a range if it looks like one
+func splitPathRange(path string) (string, addressRange, error) {
+	index := strings.LastIndex(path, ":")
+	if index < 0 {
+		return path, fullAddressRange, nil
+	}
+	suffix := path[index+1:]
+	if suffix != "*" && !strings.ContainsAny(suffix, "-+") {
+		return path, fullAddressRange, nil
+	}
+	r, err := parseAddressRange(suffix)
+	return path[:index], r, err
+}
+
+//dumpMemory writes a canonical hexdump of the range, gaps between segments are marked and repeated lines collapse to *
+//When symbols are given each section and symbol is labelled above the line it starts in
+func dumpMemory(w io.Writer, mem *gohex.Memory, r addressRange, wordBytes int, bigEndian bool, symbols *symbolTable) error {
+	var lastAddress uint64
+	started := false
+	for _, segment := range mem.GetDataSegments() {
+		address, data, ok := r.clip(segment)
+		if !ok {
+			continue
+		}
+		start := uint64(address)
+		if started && start > lastAddress {
+			if _, err := fmt.Fprintf(w, "-- gap of 0x%X bytes --\n", start-lastAddress); err != nil {
+				return err
+			}
+		}
+		started = true
+		var previous []byte
+		collapsed := false
+		end := start + uint64(len(data))
+		for lineAddress := alignDown(start, dumpLineLength); lineAddress < end; lineAddress += dumpLineLength {
+			lineStart := lineAddress
+			if lineStart < start {
+				lineStart = start
+			}
+			lineEnd := lineAddress + dumpLineLength
+			if lineEnd > end {
+				lineEnd = end
+			}
+			line := data[lineStart-start : lineEnd-start]
+			full := len(line) == dumpLineLength
+			labels := symbols.labels(addressRange{start: lineStart, end: lineEnd})
+			if full && len(labels) == 0 && bytes.Equal(line, previous) {
+				//Only the first repeat is marked, but the final line of a segment is always shown
+				if lineEnd == end {
+					collapsed = false
+				} else {
+					if !collapsed {
+						if _, err := fmt.Fprintln(w, "*"); err != nil {
+							return err
+						}
+					}
+					collapsed = true
+					continue
+				}
+			}
+			collapsed = false
+			if full {
+				previous = line
+			}
+			for _, label := range labels {
+				if _, err := fmt.Fprintf(w, "<%s>:\n", label); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintln(w, formatDumpLine(lineAddress, int(lineStart-lineAddress), line, wordBytes, bigEndian)); err != nil {
+				return err
+			}
+		}
+		lastAddress = end
+	}
+	return nil
+}
+
+//formatDumpLine renders one line, skip is how many columns at the start of the line hold no data
+func formatDumpLine(address uint64, skip int, line []byte, wordBytes int, bigEndian bool) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%08X ", address)
+	for column := 0; column < dumpLineLength; column += wordBytes {
+		if column == dumpLineLength/2 && wordBytes == 1 {
+			text.WriteString(" ")
+		}
+		text.WriteString(" ")
+		for i := 0; i < wordBytes; i++ {
+			index := column + i
+			if bigEndian == false {
+				//Little endian words print their highest address byte first
+				index = column + wordBytes - 1 - i
+			}
+			if index < skip || index-skip >= len(line) {
+				text.WriteString("  ")
+			} else {
+				fmt.Fprintf(&text, "%02X", line[index-skip])
+			}
+		}
+	}
+	text.WriteString("  |")
+	text.WriteString(strings.Repeat(" ", skip))
+	for _, b := range line {
+		if b >= 0x20 && b < 0x7F {
+			text.WriteByte(b)
+		} else {
+			text.WriteByte('.')
+		}
+	}
+	text.WriteString(strings.Repeat(" ", dumpLineLength-skip-len(line)))
+	text.WriteString("|")
+	return text.String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestSplitPathRange(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		path     string
+		wantPath string
+		wantR    addressRange
+		wantErr  bool
+	}{
+		{"test.hex", "test.hex", fullAddressRange, false},
+		{"test.bin:0x100", "test.bin:0x100", fullAddressRange, false},
+		{"test.hex:0x100-0x200", "test.hex", addressRange{0x100, 0x200}, false},
+		{"test.bin:0x100:0x100+16", "test.bin:0x100", addressRange{0x100, 0x110}, false},
+		{"test.hex:*", "test.hex", fullAddressRange, false},
+		{"test.hex:0x200-0x100", "test.hex", addressRange{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path, r, err := splitPathRange(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if path != tt.wantPath || r != tt.wantR {
+				t.Errorf("got %v %v, want %v %v", path, r, tt.wantPath, tt.wantR)
+			}
+		})
+	}
+}
+
+func TestDumpMemory(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x1002, []byte("0123456789abcdefghijklmnopqrstuvwxyz"))
+	mem.AddBinary(0x2000, bytes.Repeat([]byte{0xFF}, 64))
+	var buffer bytes.Buffer
+	err := dumpMemory(&buffer, mem, fullAddressRange, 1, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"00001000        30 31 32 33 34 35  36 37 38 39 61 62 63 64  |  0123456789abcd|",
+		"00001010  65 66 67 68 69 6A 6B 6C  6D 6E 6F 70 71 72 73 74  |efghijklmnopqrst|",
+		"00001020  75 76 77 78 79 7A                                 |uvwxyz          |",
+		"-- gap of 0xFDA bytes --",
+		"00002000  FF FF FF FF FF FF FF FF  FF FF FF FF FF FF FF FF  |................|",
+		"*",
+		"00002030  FF FF FF FF FF FF FF FF  FF FF FF FF FF FF FF FF  |................|",
+		"",
+	}
+	if buffer.String() != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", buffer.String(), strings.Join(want, "\n"))
+	}
+}
+
+func TestDumpMemoryWords(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x1000, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17})
+	var buffer bytes.Buffer
+	err := dumpMemory(&buffer, mem, addressRange{0x1000, 0x1012}, 4, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "00001000  03020100 07060504 0B0A0908 0F0E0D0C  |................|\n" +
+		"00001010      1110                             |..              |\n"
+	if buffer.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buffer.String(), want)
+	}
+	buffer.Reset()
+	err = dumpMemory(&buffer, mem, addressRange{0x1000, 0x1004}, 2, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "00001000  0001 0203                                |....            |\n"
+	if buffer.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buffer.String(), want)
+	}
+}
+
+func TestDumpMemorySymbols(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x1000, bytes.Repeat([]byte{0}, 64))
+	table := &symbolTable{sections: []symbol{{".text", 0x1000, 0x40}}, symbols: []symbol{{"main", 0x1024, 0x10}}}
+	table.finish()
+	var buffer bytes.Buffer
+	err := dumpMemory(&buffer, mem, fullAddressRange, 1, false, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"<.text>:",
+		"00001000  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|",
+		"*",
+		"<main>:",
+		"00001020  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|",
+		"00001030  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|",
+		"",
+	}
+	if buffer.String() != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", buffer.String(), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+const (
+	ctrIVBytes   = aes.BlockSize
+	gcmTagBytes  = 16
+	gcmNonceSize = 12
+)
+
+//cryptSettings are shared by every --encrypt and --decrypt, so --encrypt-fill applies wherever it is given
+type cryptSettings struct {
+	fill byte
+}
+
+//loadAESKey reads a 128, 192 or 256 bit key, either as raw bytes or as hex text
+func loadAESKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := hex.DecodeString(string(bytes.TrimSpace(data))); err == nil {
+		data = key
+	}
+	if len(data) != 16 && len(data) != 24 && len(data) != 32 {
+		return nil, fmt.Errorf("key in %s is %d bytes, should be 16, 24 or 32", path, len(data))
+	}
+	return data, nil
+}
+
+//cryptTrailerLength is how many bytes the IV and tag take up after the encrypted range
+func cryptTrailerLength(mode string) int {
+	if mode == "gcm" {
+		return gcmNonceSize + gcmTagBytes
+	}
+	return ctrIVBytes
+}
+
+//parseCryptOption parses KEYFILE:MODE:RANGE for --encrypt and --decrypt, mode is ctr or gcm
+func parseCryptOption(value string, decrypt bool, settings *cryptSettings) (transform, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) < 2 {
+		return transform{}, fmt.Errorf("should be KEYFILE:MODE:RANGE")
+	}
+	key, err := loadAESKey(parts[0])
+	if err != nil {
+		return transform{}, err
+	}
+	mode := strings.ToLower(parts[1])
+	if mode != "ctr" && mode != "gcm" {
+		return transform{}, fmt.Errorf("unknown mode %s, should be ctr or gcm", parts[1])
+	}
+	rangeText := ""
+	if len(parts) == 3 {
+		rangeText = parts[2]
+	}
+	r, err := parseAddressRange(rangeText)
+	if err != nil {
+		return transform{}, err
+	}
+	if decrypt {
+		return transform{
+			name: fmt.Sprintf("decrypt %v with AES-%s", r, strings.ToUpper(mode)),
+			apply: func(mem *gohex.Memory) error {
+				encrypted := imageRange(mem, r)
+				if r == fullAddressRange {
+					//The trailer is the end of the image rather than part of the data
+					if encrypted.end-encrypted.start < uint64(cryptTrailerLength(mode)) {
+						return fmt.Errorf("image is too small to hold a %d byte trailer", cryptTrailerLength(mode))
+					}
+					encrypted.end -= uint64(cryptTrailerLength(mode))
+				}
+				return decryptMemory(mem, encrypted, key, mode, settings.fill)
+			},
+		}, nil
+	}
+	return transform{
+		name: fmt.Sprintf("encrypt %v with AES-%s", r, strings.ToUpper(mode)),
+		apply: func(mem *gohex.Memory) error {
+			return encryptMemory(mem, imageRange(mem, r), key, mode, settings.fill)
+		},
+	}, nil
+}
+
+//encryptMemory encrypts the range in place and writes the trailer straight after it
+//CTR writes the 16 byte IV, GCM writes the 12 byte nonce followed by the 16 byte tag
+func encryptMemory(mem *gohex.Memory, r addressRange, key []byte, mode string, fill byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if r.end <= r.start {
+		return fmt.Errorf("nothing to encrypt")
+	}
+	trailerRange := addressRange{start: r.end, end: r.end + uint64(cryptTrailerLength(mode))}
+	if trailerRange.end > 1<<32 {
+		return fmt.Errorf("no room for the trailer after 0x%08X", r.end)
+	}
+	if address, b, found := findData(mem, trailerRange, fill); found {
+		return fmt.Errorf("trailer would overwrite data 0x%02X @ 0x%08X", b, address)
+	}
+	plaintext := readMemory(mem, r, fill)
+	var ciphertext, trailer []byte
+	if mode == "gcm" {
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, gcmNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		sealed := aead.Seal(nil, nonce, plaintext, nil)
+		ciphertext = sealed[:len(plaintext)]
+		trailer = append(nonce, sealed[len(plaintext):]...)
+	} else {
+		iv := make([]byte, ctrIVBytes)
+		if _, err := rand.Read(iv); err != nil {
+			return err
+		}
+		ciphertext = make([]byte, len(plaintext))
+		cipher.NewCTR(block, iv).XORKeyStream(ciphertext, plaintext)
+		trailer = iv
+	}
+	if err := writeMemory(mem, uint32(r.start), ciphertext); err != nil {
+		return err
+	}
+	fmt.Printf("Encrypted %v, %d byte trailer @ 0x%08X\n", r, len(trailer), r.end)
+	return writeMemory(mem, uint32(r.end), trailer)
+}
+
+//decryptMemory reverses encryptMemory, checking the GCM tag and removing the trailer
+func decryptMemory(mem *gohex.Memory, r addressRange, key []byte, mode string, fill byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if r.end <= r.start {
+		return fmt.Errorf("nothing to decrypt")
+	}
+	trailerRange := addressRange{start: r.end, end: r.end + uint64(cryptTrailerLength(mode))}
+	if len(memoryGaps(mem, trailerRange)) > 0 {
+		return fmt.Errorf("no complete trailer @ 0x%08X", r.end)
+	}
+	trailer := readMemory(mem, trailerRange, fill)
+	ciphertext := readMemory(mem, r, fill)
+	var plaintext []byte
+	if mode == "gcm" {
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		plaintext, err = aead.Open(nil, trailer[:gcmNonceSize], append(ciphertext, trailer[gcmNonceSize:]...), nil)
+		if err != nil {
+			return fmt.Errorf("decrypting %v failed => %v", r, err)
+		}
+	} else {
+		plaintext = make([]byte, len(ciphertext))
+		cipher.NewCTR(block, trailer).XORKeyStream(plaintext, ciphertext)
+	}
+	if err := writeMemory(mem, uint32(r.start), plaintext); err != nil {
+		return err
+	}
+	mem.RemoveBinary(uint32(trailerRange.start), uint32(trailerRange.end-trailerRange.start))
+	fmt.Printf("Decrypted %v\n", r)
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestLoadAESKey(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name    string
+		content []byte
+		want    int
+		wantErr bool
+	}{
+		{"raw128", make([]byte, 16), 16, false},
+		{"raw256", make([]byte, 32), 32, false},
+		{"hex", []byte("000102030405060708090a0b0c0d0e0f\n"), 16, false},
+		{"short", make([]byte, 15), 0, true},
+		{"badhex", []byte("00010203040506070809"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpfile, err := os.CreateTemp("", "*_aes.key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(tmpfile.Name())
+			tmpfile.Write(tt.content)
+			tmpfile.Close()
+			key, err := loadAESKey(tmpfile.Name())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if len(key) != tt.want {
+				t.Errorf("got %d byte key, want %d", len(key), tt.want)
+			}
+		})
+	}
+	key, _ := os.CreateTemp("", "*_aes.key")
+	defer os.Remove(key.Name())
+	key.WriteString("000102030405060708090a0b0c0d0e0f")
+	key.Close()
+	data, err := loadAESKey(key.Name())
+	if err != nil || data[15] != 0x0F {
+		t.Errorf("Hex keys should be decoded, got %v %v", data, err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, mode := range []string{"ctr", "gcm"} {
+		t.Run(mode, func(t *testing.T) {
+			mem := gohex.NewMemory()
+			mem.AddBinary(0x1000, []byte{1, 2, 3, 4})
+			mem.AddBinary(0x1006, []byte{5, 6})
+			r := addressRange{0x1000, 0x1008}
+			err := encryptMemory(mem, r, key, mode, 0xFF)
+			if err != nil {
+				t.Fatal(err)
+			}
+			segments := mem.GetDataSegments()
+			if len(segments) != 1 || len(segments[0].Data) != 8+cryptTrailerLength(mode) {
+				t.Fatalf("Range and trailer should be one segment, got %v", segments)
+			}
+			if reflect.DeepEqual(segments[0].Data[:4], []byte{1, 2, 3, 4}) {
+				t.Error("Data should be encrypted")
+			}
+			err = decryptMemory(mem, r, key, mode, 0xFF)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []gohex.DataSegment{{Address: 0x1000, Data: []byte{1, 2, 3, 4, 0xFF, 0xFF, 5, 6}}}
+			if !reflect.DeepEqual(mem.GetDataSegments(), want) {
+				t.Errorf("got %v, want %v", mem.GetDataSegments(), want)
+			}
+		})
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	t.Parallel()
+	key := make([]byte, 16)
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x1000, []byte{1, 2, 3, 4})
+	r := addressRange{0x1000, 0x1004}
+	if err := encryptMemory(mem, r, key, "gcm", 0xFF); err != nil {
+		t.Fatal(err)
+	}
+	data := mem.GetDataSegments()[0].Data
+	data[0] ^= 1
+	if err := decryptMemory(mem, r, key, "gcm", 0xFF); err == nil {
+		t.Error("Should raise error when the GCM tag doesn't match")
+	}
+	if err := decryptMemory(mem, addressRange{0x1000, 0x1010}, key, "gcm", 0xFF); err == nil {
+		t.Error("Should raise error without a trailer")
+	}
+}
+
+func TestDecryptSmallImage(t *testing.T) {
+	t.Parallel()
+	keyFile, err := os.CreateTemp("", "*_aes.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keyFile.Name())
+	keyFile.Write(make([]byte, 16))
+	keyFile.Close()
+	decrypt, err := parseCryptOption(keyFile.Name()+":gcm:*", true, &cryptSettings{fill: 0xFF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//A whole image decrypt takes the trailer from the end, so images shorter than it are an error rather than a wrapped range
+	for _, length := range []int{0, 4, 27} {
+		mem := gohex.NewMemory()
+		if length > 0 {
+			mem.AddBinary(0x1000, make([]byte, length))
+		}
+		if err := decrypt.apply(mem); err == nil {
+			t.Errorf("Should raise error decrypting a %d byte image", length)
+		}
+	}
+}
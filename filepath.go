@@ -51,6 +51,18 @@ func parseNumberString(data string) (uint32, error) {
 	return uint32(n), err
 }
 
+//parseByte parses a number that has to fit in a single byte
+func parseByte(data string) (byte, error) {
+	n, err := parseNumberString(data)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0xFF {
+		return 0, fmt.Errorf("value 0x%X does not fit in a byte", n)
+	}
+	return byte(n), nil
+}
+
 // parseFileTypeAndStart returns if the path specifies a hex file or not, and if its a binary if it contains a starting address
 // This parses a format of test.bin:0x5000 -> binary + start @ 0x5000
 func parseFileTypeAndStart(path string) (isHexFile bool, binaryStart uint32, filteredPath string, err error) {
@@ -64,7 +76,7 @@ func parseFileTypeAndStart(path string) (isHexFile bool, binaryStart uint32, fil
 	if extension == ".hex" {
 		return true, 0, baseName, nil
 	}
-	if extension == ".bin" && len(parts) == 1 {
+	if (extension == ".bin" || isSegmentIndex(baseName)) && len(parts) == 1 {
 		return false, 0, baseName, nil
 	}
 	if len(parts) == 2 {
@@ -151,6 +151,9 @@ func TestParseFileTypeAndStart(t *testing.T) {
 		{"test.bad:0x1024", "test.bad", false, 0, fmt.Errorf("could not parse file type from test.bad:0x1024")},
 		{"test.bad", "test.bad", false, 0, fmt.Errorf("could not parse file type from test.bad")},
 		{"test.bin:x", "test.bin", false, 0, fmt.Errorf("could not parse file type from test.bin:x")},
+		{"test.json", "test.json", false, 0, nil},
+		{"test.csv", "test.csv", false, 0, nil},
+		{"test.csv:0x100", "test.csv", false, 0, fmt.Errorf("could not parse file type from test.csv:0x100")},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//parsePattern parses a fill pattern, either a single number that fits in a byte or a 0x prefixed run of hex bytes
+//0xDEADBEEF -> DE AD BE EF in address order
+func parsePattern(data string) ([]byte, error) {
+	if strings.HasPrefix(data, "0x") && len(data) > 4 {
+		pattern, err := hex.DecodeString(data[2:])
+		if err != nil {
+			return nil, fmt.Errorf("pattern %s should be an even number of hex digits", data)
+		}
+		return pattern, nil
+	}
+	value, err := parseByte(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte{value}, nil
+}
+
+//parseFillOption parses RANGE:PATTERN for --fill and --fill-gaps
+func parseFillOption(value string, gapsOnly bool) (transform, error) {
+	index := strings.LastIndex(value, ":")
+	if index < 0 {
+		return transform{}, fmt.Errorf("should be RANGE:PATTERN")
+	}
+	r, err := parseAddressRange(value[:index])
+	if err != nil {
+		return transform{}, err
+	}
+	pattern, err := parsePattern(value[index+1:])
+	if err != nil {
+		return transform{}, err
+	}
+	name := fmt.Sprintf("fill %v with 0x%X", r, pattern)
+	if gapsOnly {
+		name = fmt.Sprintf("fill gaps in %v with 0x%X", r, pattern)
+	}
+	return transform{
+		name: name,
+		apply: func(mem *gohex.Memory) error {
+			return fillRange(mem, imageRange(mem, r), pattern, gapsOnly)
+		},
+	}, nil
+}
+
+//imageRange narrows the full address range down to the span the image actually covers
+//Any other range is returned as is
+func imageRange(mem *gohex.Memory, r addressRange) addressRange {
+	if r != fullAddressRange {
+		return r
+	}
+	segments := mem.GetDataSegments()
+	if len(segments) == 0 {
+		return addressRange{}
+	}
+	last := segments[len(segments)-1]
+	return addressRange{start: uint64(segments[0].Address), end: uint64(last.Address) + uint64(len(last.Data))}
+}
+
+//patternAt returns length bytes of the pattern as it would appear from address, the pattern repeats from the start of the range
+func patternAt(pattern []byte, r addressRange, address uint64, length int) []byte {
+	data := make([]byte, length)
+	offset := int((address - r.start) % uint64(len(pattern)))
+	for i := range data {
+		data[i] = pattern[(offset+i)%len(pattern)]
+	}
+	return data
+}
+
+//fillRange writes the repeating pattern over the range, if gapsOnly is set existing data is left alone
+func fillRange(mem *gohex.Memory, r addressRange, pattern []byte, gapsOnly bool) error {
+	if !gapsOnly {
+		for _, segment := range mem.GetDataSegments() {
+			address, data, ok := r.clip(segment)
+			if ok {
+				copy(data, patternAt(pattern, r, uint64(address), len(data)))
+			}
+		}
+	}
+	for _, gap := range memoryGaps(mem, r) {
+		if err := mem.AddBinary(uint32(gap.start), patternAt(pattern, r, gap.start, int(gap.end-gap.start))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestParsePattern(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		data    string
+		want    []byte
+		wantErr bool
+	}{
+		{"0xFF", []byte{0xFF}, false},
+		{"255", []byte{0xFF}, false},
+		{"0", []byte{0}, false},
+		{"0xDEADBEEF", []byte{0xDE, 0xAD, 0xBE, 0xEF}, false},
+		{"0xDEADBEE", nil, true},
+		{"256", nil, true},
+		{"nope", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			pattern, err := parsePattern(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(pattern, tt.want) {
+				t.Errorf("got %v, want %v", pattern, tt.want)
+			}
+		})
+	}
+}
+
+func TestFillRange(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name     string
+		r        addressRange
+		gapsOnly bool
+		want     []byte
+	}{
+		{"gaps", addressRange{0x100, 0x108}, true, []byte{0xAA, 0xBB, 1, 2, 0xAA, 0xBB, 3, 0xBB}},
+		{"all", addressRange{0x100, 0x108}, false, []byte{0xAA, 0xBB, 0xAA, 0xBB, 0xAA, 0xBB, 0xAA, 0xBB}},
+		{"image", fullAddressRange, true, []byte{1, 2, 0xAA, 0xBB, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := gohex.NewMemory()
+			mem.AddBinary(0x102, []byte{1, 2})
+			mem.AddBinary(0x106, []byte{3})
+			err := fillRange(mem, imageRange(mem, tt.r), []byte{0xAA, 0xBB}, tt.gapsOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			segments := mem.GetDataSegments()
+			if len(segments) != 1 || !reflect.DeepEqual(segments[0].Data, tt.want) {
+				t.Errorf("got %v, want %v", segments, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageRange(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	if imageRange(mem, fullAddressRange) != (addressRange{}) {
+		t.Error("Empty image should have an empty range")
+	}
+	mem.AddBinary(0x100, []byte{1, 2})
+	mem.AddBinary(0x200, []byte{3})
+	if got := imageRange(mem, fullAddressRange); got != (addressRange{0x100, 0x201}) {
+		t.Errorf("got %v", got)
+	}
+	if got := imageRange(mem, addressRange{0, 4}); got != (addressRange{0, 4}) {
+		t.Errorf("Explicit range should be unchanged, got %v", got)
+	}
+}
@@ -2,4 +2,4 @@ module github.com/ralim/hexm
 
 go 1.16
 
-require github.com/marcinbor85/gohex v0.0.0-20210308104911-55fb1c624d84 // indirect
+require github.com/marcinbor85/gohex v0.0.0-20210308104911-55fb1c624d84
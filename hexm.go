@@ -7,35 +7,218 @@ import (
 	"github.com/marcinbor85/gohex"
 )
 
+//commands are the sub commands picked by the first argument, without one hexm merges the files given
+var commands = map[string]func(args []string) error{
+	"dump":     runDump,
+	"hash":     runHash,
+	"verify":   runVerify,
+	"genpatch": runGenPatch,
+	"delta":    runDelta,
+	"undelta":  runUndelta,
+	"lint":     runLint,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 	//As we only have trivial command line args, simpler to custom parse
-	inputFiles, outputFile, err := parseArgs(os.Args[1:])
+	//Anything starting with -- is an option, the rest are the files
+	options, args := splitOptions(os.Args[1:])
+	settings, err := parseMergeOptions(options)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error %v\n", err)
-		return
+		fail(err)
+	}
+	inputFiles, outputFile, err := parseArgs(args)
+	if err != nil {
+		fail(err)
 	}
 	fmt.Printf("Input Files: %v\n", inputFiles)
 	fmt.Printf("Output file: %s\n", outputFile)
-	err = validateFiles(inputFiles, outputFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error %v\n", err)
+	outputFiles := []string{outputFile}
+	if settings.splitLanes.lanes > 0 {
+		outputFiles = []string{}
+		for lane := 0; lane < int(settings.splitLanes.lanes); lane++ {
+			outputFiles = append(outputFiles, laneOutputPath(outputFile, lane))
+		}
+	}
+	if settings.dryRun {
+		fmt.Printf("Dry run, nothing will be written\n")
+	}
+	for _, output := range outputFiles {
+		if settings.dryRun {
+			err = checkDryRunFiles(inputFiles, output)
+		} else {
+			err = validateFiles(inputFiles, output)
+		}
+		if isHex, _, path, _ := parseFileTypeAndStart(output); err == nil {
+			err = checkOutputOptions(isHex, path, settings.output)
+		}
+		if err != nil {
+			fail(err)
+		}
+	}
+	if settings.report != "" {
+		if err := validateReportFile(settings.report); err != nil {
+			fail(err)
+		}
+	}
+	if settings.keepLayout && settings.output.layout == "" {
+		for _, input := range inputFiles {
+			if isHex, _, path, err := parseFileTypeAndStart(input); err == nil && isHex {
+				settings.output.layout = path
+				break
+			}
+		}
+		if settings.output.layout == "" {
+			fail(fmt.Errorf("--preserve-layout needs a hex input or a hex file to follow"))
+		}
+	}
+	if canStream(settings, inputFiles, outputFile) {
+		//Plain conversions never need the whole image in memory
+		if err := streamConvert(inputFiles[0], outputFile); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Output %s created\n", outputFile)
 		return
 	}
-	outputMemory := gohex.NewMemory()
+	var report *runReport
+	if settings.report != "" {
+		report = newRunReport()
+	}
+	failed := false
+	//Inputs are loaded and merged as pages on disk, so the image never has to fit in memory
+	store, err := newPageStore()
+	if err != nil {
+		fail(err)
+	}
+	defer store.close()
+	merged := newPagedImage(store)
+	lanes := []*gohex.Memory{}
 	//Parse all input files into virtual memory space
 	for i, inputFilePath := range inputFiles {
 		fmt.Printf("Loading file %d => %s\r\n", i+1, inputFilePath)
-		mem, err := parseInputFile(inputFilePath)
+		var img *pagedImage
+		if settings.lenient {
+			img, err = loadImageLenient(inputFilePath, store)
+		} else {
+			img, err = loadImage(inputFilePath, store)
+		}
 		if err != nil {
+			//A report has to describe exactly what was loaded, so a bad input stops the run
+			if report != nil {
+				fail(fmt.Errorf("reading %s => %v", inputFilePath, err))
+			}
 			fmt.Printf("Reading Input file raised error %v", err)
+			failed = true
+		}
+		if err := report.addInput(inputFilePath, img); err != nil {
+			fail(err)
+		}
+		if settings.combineLanes.lanes > 0 {
+			mem, err := toMemory(img)
+			if err != nil {
+				fail(err)
+			}
+			lanes = append(lanes, mem)
+			continue
+		}
+		var confirm func(segment addressRange, source string) bool
+		if settings.dryRun {
+			confirm = dryRunConfirm
+		}
+		overlaps, err := mergeSegments(merged, img, inputFilePath, settings.symbols, confirm)
+		if err != nil {
+			fail(err)
+		}
+		report.addOverlaps(overlaps, settings.symbols)
+	}
+	var outputImage image = merged
+	var outputMemory *gohex.Memory
+	if settings.combineLanes.lanes > 0 {
+		fmt.Printf("Combining %d lanes of %d bytes\n", settings.combineLanes.lanes, settings.combineLanes.width)
+		combined, err := combineLanes(lanes, settings.combineLanes)
+		if err != nil {
+			fail(err)
+		}
+		outputImage = memoryImage{combined}
+	}
+	if len(settings.transforms) > 0 || settings.splitLanes.lanes > 0 || settings.output.layout != "" {
+		//These change or rearrange the image in place, so it is read into memory for them
+		if outputMemory, err = toMemory(outputImage); err != nil {
+			fail(err)
+		}
+		for _, t := range settings.transforms {
+			fmt.Printf("Applying %s\n", t.name)
+			report.addTransform(t.name)
+			if err := t.apply(outputMemory); err != nil {
+				fail(err)
+			}
+		}
+		outputImage = memoryImage{outputMemory}
+	}
+	if settings.device != nil {
+		if err := reportMemoryMap(outputImage, *settings.device); err != nil {
+			fail(err)
 		}
-		mergeSegments(outputMemory, mem, inputFilePath)
 	}
 	// Now we want to write out the file, if its hex then we can use the hex writer, otherwise we will want to persist it out to bin
-	err = writeOutput(outputFile, outputMemory)
-	if err == nil {
-		fmt.Println("Output created")
-	} else {
-		fmt.Printf("Creating output file raised error %v", err)
+	outputImages := []image{outputImage}
+	if settings.splitLanes.lanes > 0 {
+		fmt.Printf("Splitting into %d lanes of %d bytes\n", settings.splitLanes.lanes, settings.splitLanes.width)
+		split, err := splitLanes(outputMemory, settings.splitLanes)
+		if err != nil {
+			fail(err)
+		}
+		outputImages = []image{}
+		for _, mem := range split {
+			outputImages = append(outputImages, memoryImage{mem})
+		}
 	}
+	if settings.dryRun {
+		printMemoryMap(outputImage, settings.symbols)
+		for i, output := range outputFiles {
+			description, err := describeOutput(output, outputImages[i], settings.output)
+			if err != nil {
+				fail(err)
+			}
+			fmt.Printf("Would write %s\n", description)
+		}
+		return
+	}
+	for i, output := range outputFiles {
+		err = writeOutput(output, outputImages[i], settings.output)
+		if err == nil {
+			fmt.Printf("Output %s created\n", output)
+		} else {
+			fmt.Printf("Creating output file raised error %v", err)
+			failed = true
+			continue
+		}
+		if err := report.addOutput(output, outputImages[i]); err != nil {
+			fail(err)
+		}
+	}
+	if failed {
+		//No report for a run that failed part way, a partial record would be a wrong one
+		fail(fmt.Errorf("not every input could be read or output created"))
+	}
+	if report != nil {
+		if err := report.write(settings.report, outputImage); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Report %s written\n", settings.report)
+	}
+}
+
+//fail ends a merge that could not be completed with a non zero exit code
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "Error %v\n", err)
+	os.Exit(1)
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//image is read access to a loaded image, either a gohex.Memory or a pagedImage that keeps its data on disk
+//Outputs are written from it a chunk at a time, so writing never needs another copy of the whole image
+type image interface {
+	//ranges lists the runs of data in address order, runs that touch are joined as gohex does
+	ranges() []addressRange
+	//readAt fills data from the address, every address read has to hold data
+	readAt(data []byte, address uint32) error
+	startAddress() (uint32, bool)
+}
+
+//writableImage is an image that inputs can be loaded and merged into
+type writableImage interface {
+	image
+	//write sets the bytes at the address, replacing any data already there
+	write(address uint32, data []byte) error
+	setStartAddress(address uint32)
+}
+
+//memoryImage is an image held in a gohex.Memory, which the transforms need to change the image in place
+type memoryImage struct {
+	mem *gohex.Memory
+}
+
+func (m memoryImage) ranges() []addressRange {
+	ranges := []addressRange{}
+	for _, segment := range m.mem.GetDataSegments() {
+		ranges = append(ranges, addressRange{start: uint64(segment.Address), end: uint64(segment.Address) + uint64(len(segment.Data))})
+	}
+	return ranges
+}
+
+func (m memoryImage) readAt(data []byte, address uint32) error {
+	r := addressRange{start: uint64(address), end: uint64(address) + uint64(len(data))}
+	copied := uint64(0)
+	for _, segment := range m.mem.GetDataSegments() {
+		start, segmentData, ok := r.clip(segment)
+		if ok {
+			copied += uint64(copy(data[uint64(start)-r.start:], segmentData))
+		}
+	}
+	if copied != r.end-r.start {
+		return fmt.Errorf("no data in part of %v", r)
+	}
+	return nil
+}
+
+func (m memoryImage) startAddress() (uint32, bool) {
+	return m.mem.GetStartAddress()
+}
+
+func (m memoryImage) write(address uint32, data []byte) error {
+	return writeMemory(m.mem, address, data)
+}
+
+func (m memoryImage) setStartAddress(address uint32) {
+	m.mem.SetStartAddress(address)
+}
+
+//toMemory returns the image as a gohex.Memory, reading all of it in unless it already is one
+func toMemory(img image) (*gohex.Memory, error) {
+	if m, ok := img.(memoryImage); ok {
+		return m.mem, nil
+	}
+	mem := gohex.NewMemory()
+	for _, r := range img.ranges() {
+		data := make([]byte, r.end-r.start)
+		if err := img.readAt(data, uint32(r.start)); err != nil {
+			return nil, err
+		}
+		if err := mem.AddBinary(uint32(r.start), data); err != nil {
+			return nil, err
+		}
+	}
+	if start, ok := img.startAddress(); ok {
+		mem.SetStartAddress(start)
+	}
+	return mem, nil
+}
+
+//readChunks calls fn with the data of each run in order, at most streamChunkLength bytes at a time
+//The runs have to hold data, callers pass ranges they already have so the image isn't listed again for each one
+//The data passed to fn is reused for the next chunk
+func readChunks(img image, runs []addressRange, fn func(address uint32, data []byte) error) error {
+	var chunk []byte
+	for _, run := range runs {
+		for run.start < run.end {
+			length := run.end - run.start
+			if length > streamChunkLength {
+				length = streamChunkLength
+			}
+			if uint64(cap(chunk)) < length {
+				chunk = make([]byte, length)
+			}
+			data := chunk[:length]
+			if err := img.readAt(data, uint32(run.start)); err != nil {
+				return err
+			}
+			if err := fn(uint32(run.start), data); err != nil {
+				return err
+			}
+			run.start += length
+		}
+	}
+	return nil
+}
+
+//imageGaps lists the spans inside the range that hold no data
+func imageGaps(img image, r addressRange) []addressRange {
+	gaps := []addressRange{}
+	cursor := r.start
+	ranges := img.ranges()
+	//Ranges ending before the range can't leave a gap in it
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].end > r.start })
+	for _, run := range ranges[i:] {
+		if run.start >= r.end {
+			break
+		}
+		if run.start > cursor {
+			gaps = append(gaps, addressRange{start: cursor, end: run.start})
+		}
+		cursor = run.end
+	}
+	if cursor < r.end {
+		gaps = append(gaps, addressRange{start: cursor, end: r.end})
+	}
+	return gaps
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//A segment index is a sparse image: one .bin per segment plus a JSON or CSV list of where each one goes
+//This avoids the padding a single .bin needs when segments are far apart
+type indexEntry struct {
+	Address jsonNumber `json:"address"`
+	Length  uint32     `json:"length"`
+	File    string     `json:"file"` //Relative to the index
+}
+
+type segmentIndex struct {
+	Segments []indexEntry `json:"segments"`
+}
+
+//isSegmentIndex returns if the path names a .json or .csv segment index
+func isSegmentIndex(path string) bool {
+	extension := strings.ToLower(filepath.Ext(path))
+	return extension == ".json" || extension == ".csv"
+}
+
+//segmentFileName is the name of the bin holding the segment, next to the index: out.json -> out.08000000.bin
+func segmentFileName(indexPath string, address uint32) string {
+	base := strings.TrimSuffix(filepath.Base(indexPath), filepath.Ext(indexPath))
+	return fmt.Sprintf("%s.%08X.bin", base, address)
+}
+
+//checkIndexOptions rejects the output options that only make sense for a single bin
+func checkIndexOptions(opts outputOptions) error {
+	if opts.padTo != 0 || opts.padByte != 0 || opts.alignSize != 0 {
+		return fmt.Errorf("--pad-to, --pad-byte and --align-size can't be used with a .json or .csv output, each segment is written as is")
+	}
+	return nil
+}
+
+//writeSegmentIndex writes every segment to its own bin and lists them in the index
+//Segment files that already exist are only overwritten after asking, before anything is written
+func writeSegmentIndex(indexPath string, img image) error {
+	directory := filepath.Dir(indexPath)
+	segments := img.ranges()
+	for _, segment := range segments {
+		if err := validateFile(filepath.Join(directory, segmentFileName(indexPath, uint32(segment.start))), false); err != nil {
+			return err
+		}
+	}
+	index := segmentIndex{Segments: []indexEntry{}}
+	for _, segment := range segments {
+		entry := indexEntry{Address: jsonNumber(segment.start), Length: uint32(segment.end - segment.start), File: segmentFileName(indexPath, uint32(segment.start))}
+		if err := writeSegmentFile(filepath.Join(directory, entry.File), img, segment); err != nil {
+			return err
+		}
+		fmt.Printf("Writing %d bytes @ %08X to %s\n", entry.Length, segment.start, entry.File)
+		index.Segments = append(index.Segments, entry)
+	}
+	var data []byte
+	if strings.ToLower(filepath.Ext(indexPath)) == ".csv" {
+		var builder strings.Builder
+		w := csv.NewWriter(&builder)
+		w.Write([]string{"address", "length", "file"})
+		for _, entry := range index.Segments {
+			w.Write([]string{fmt.Sprintf("0x%08X", uint32(entry.Address)), strconv.FormatUint(uint64(entry.Length), 10), entry.File})
+		}
+		w.Flush()
+		data = []byte(builder.String())
+	} else {
+		var err error
+		if data, err = json.MarshalIndent(index, "", "  "); err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+	return ioutil.WriteFile(indexPath, data, 0644)
+}
+
+//writeSegmentFile writes one segment to its bin a chunk at a time
+func writeSegmentFile(path string, img image, segment addressRange) error {
+	file, err := createOutput(img, path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return readChunks(img, []addressRange{segment}, func(address uint32, data []byte) error {
+		_, err := file.WriteAt(data, int64(uint64(address)-segment.start))
+		return err
+	})
+}
+
+//readSegmentIndex parses a JSON or CSV index, the CSV header row is optional
+func readSegmentIndex(indexPath string) (segmentIndex, error) {
+	data, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return segmentIndex{}, err
+	}
+	index := segmentIndex{}
+	if strings.ToLower(filepath.Ext(indexPath)) != ".csv" {
+		if err := json.Unmarshal(data, &index); err != nil {
+			return segmentIndex{}, fmt.Errorf("could not parse index %s => %v", indexPath, err)
+		}
+		return index, nil
+	}
+	r := csv.NewReader(strings.NewReader(string(data)))
+	r.FieldsPerRecord = 3
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return segmentIndex{}, fmt.Errorf("could not parse index %s => %v", indexPath, err)
+	}
+	for i, record := range records {
+		if i == 0 && strings.EqualFold(record[0], "address") {
+			continue
+		}
+		address, err := parseNumberString(record[0])
+		if err != nil {
+			return segmentIndex{}, fmt.Errorf("%s line %d: invalid address %s", indexPath, i+1, record[0])
+		}
+		length, err := parseNumberString(record[1])
+		if err != nil {
+			return segmentIndex{}, fmt.Errorf("%s line %d: invalid length %s", indexPath, i+1, record[1])
+		}
+		index.Segments = append(index.Segments, indexEntry{Address: jsonNumber(address), Length: length, File: record[2]})
+	}
+	return index, nil
+}
+
+//openIndexEntry opens the bin of an index entry, checking it is the length the index says and fits in the address space
+func openIndexEntry(indexPath string, entry indexEntry) (*os.File, error) {
+	path := entry.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(indexPath), path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() != int64(entry.Length) {
+		file.Close()
+		return nil, fmt.Errorf("%s is %d bytes but the index says %d", entry.File, info.Size(), entry.Length)
+	}
+	if uint64(entry.Address)+uint64(entry.Length) > 1<<32 {
+		file.Close()
+		return nil, fmt.Errorf("%s @ 0x%08X runs past the end of the address space", entry.File, uint32(entry.Address))
+	}
+	return file, nil
+}
+
+//loadSegmentIndex loads the bins listed in an index, checking each one is the length the index says
+func loadSegmentIndex(indexPath string) (*gohex.Memory, error) {
+	mem := gohex.NewMemory()
+	index, err := readSegmentIndex(indexPath)
+	if err != nil {
+		return mem, err
+	}
+	for _, entry := range index.Segments {
+		file, err := openIndexEntry(indexPath, entry)
+		if err != nil {
+			return mem, err
+		}
+		data, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return mem, err
+		}
+		if err := mem.AddBinary(uint32(entry.Address), data); err != nil {
+			return mem, fmt.Errorf("%s @ 0x%08X => %v", entry.File, uint32(entry.Address), err)
+		}
+	}
+	return mem, nil
+}
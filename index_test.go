@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestSegmentIndexRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, extension := range []string{".json", ".csv"} {
+		t.Run(extension, func(t *testing.T) {
+			directory, err := os.MkdirTemp("", "index")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(directory)
+			mem := gohex.NewMemory()
+			mem.AddBinary(0x08000000, []byte{1, 2, 3, 4})
+			mem.AddBinary(0x90000000, []byte{5, 6})
+			indexPath := filepath.Join(directory, "image"+extension)
+			if err := writeOutput(indexPath, memoryImage{mem}, outputOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			//Bin only options would be silently lost, so they are rejected before anything is written
+			for _, opts := range []outputOptions{{padTo: 0x100}, {padByte: 0xFF}, {alignSize: 0x100}} {
+				if err := writeOutput(filepath.Join(directory, "padded"+extension), memoryImage{mem}, opts); err == nil {
+					t.Errorf("Should raise error on %+v", opts)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(directory, "padded.08000000.bin")); err == nil {
+				t.Error("Rejected outputs should not write segment files")
+			}
+			data, err := ioutil.ReadFile(filepath.Join(directory, "image.90000000.bin"))
+			if err != nil || !reflect.DeepEqual(data, []byte{5, 6}) {
+				t.Errorf("Segment file should hold the segment, got %v %v", data, err)
+			}
+			loaded, err := parseInputFile(indexPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded.GetDataSegments(), mem.GetDataSegments()) {
+				t.Errorf("got %v, want %v", loaded.GetDataSegments(), mem.GetDataSegments())
+			}
+		})
+	}
+}
+
+func TestReadSegmentIndex(t *testing.T) {
+	t.Parallel()
+	directory, err := os.MkdirTemp("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	ioutil.WriteFile(filepath.Join(directory, "a.bin"), []byte{1, 2, 3}, 0644)
+	var tests = []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"plain.json", `{"segments": [{"address": 4096, "length": 3, "file": "a.bin"}]}`, false},
+		{"hex.json", `{"segments": [{"address": "0x1000", "length": 3, "file": "a.bin"}]}`, false},
+		{"noheader.csv", "0x1000, 3, a.bin\n", false},
+		{"header.csv", "address,length,file\n4096,3,a.bin\n", false},
+		{"short.csv", "0x1000,4,a.bin\n", true},
+		{"missing.csv", "0x1000,3,b.bin\n", true},
+		{"columns.csv", "0x1000,3\n", true},
+		{"overlap.csv", "0x1000,3,a.bin\n0x1001,3,a.bin\n", true},
+		{"end.json", `{"segments": [{"address": "0xFFFFFFFF", "length": 3, "file": "a.bin"}]}`, true},
+		{"bad.json", `{"segments": [{"address": "zz", "length": 3, "file": "a.bin"}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(directory, tt.name)
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mem, err := loadSegmentIndex(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				segments := mem.GetDataSegments()
+				if len(segments) != 1 || segments[0].Address != 0x1000 || !reflect.DeepEqual(segments[0].Data, []byte{1, 2, 3}) {
+					t.Errorf("got %v", segments)
+				}
+			}
+		})
+	}
+}
+
+func TestSegmentFileName(t *testing.T) {
+	t.Parallel()
+	if name := segmentFileName(filepath.Join("out", "fw.json"), 0x08000000); name != "fw.08000000.bin" {
+		t.Errorf("got %s", name)
+	}
+	if !isSegmentIndex("x.CSV") || isSegmentIndex("x.bin") || strings.HasSuffix(segmentFileName("a.csv", 0), ".csv") {
+		t.Errorf("isSegmentIndex should match .json and .csv only")
+	}
+}
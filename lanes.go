@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//maxLanes bounds the lane count, each lane is a whole memory and output file
+const maxLanes = 64
+
+//laneLayout describes an image interleaved across parallel memories, width bytes go to each lane in turn
+type laneLayout struct {
+	lanes uint32
+	width uint32
+}
+
+//parseLaneOption parses LANES[:WIDTH], width is in bytes and defaults to 1
+func parseLaneOption(value string) (laneLayout, error) {
+	parts := strings.SplitN(value, ":", 2)
+	lanes, err := parseNumberString(parts[0])
+	if err != nil {
+		return laneLayout{}, err
+	}
+	width := uint32(1)
+	if len(parts) == 2 {
+		width, err = parseNumberString(parts[1])
+		if err != nil {
+			return laneLayout{}, err
+		}
+	}
+	if lanes < 2 || width < 1 {
+		return laneLayout{}, fmt.Errorf("need at least 2 lanes of at least 1 byte")
+	}
+	if lanes > maxLanes {
+		return laneLayout{}, fmt.Errorf("%d lanes is more than the limit of %d", lanes, maxLanes)
+	}
+	return laneLayout{lanes: lanes, width: width}, nil
+}
+
+//laneOutputPath inserts the lane number in front of the file extension, keeping any :start suffix
+//out.bin:0x100 -> out.lane0.bin:0x100
+func laneOutputPath(path string, lane int) string {
+	parts := strings.SplitN(path, ":", 2)
+	extension := filepath.Ext(parts[0])
+	parts[0] = fmt.Sprintf("%s.lane%d%s", strings.TrimSuffix(parts[0], extension), lane, extension)
+	return strings.Join(parts, ":")
+}
+
+//splitLanes breaks the memory into one memory per lane, lane addresses are the combined address divided by the lane count
+func splitLanes(mem *gohex.Memory, layout laneLayout) ([]*gohex.Memory, error) {
+	lanes := make([]*gohex.Memory, layout.lanes)
+	for i := range lanes {
+		lanes[i] = gohex.NewMemory()
+	}
+	width := uint64(layout.width)
+	stride := width * uint64(layout.lanes)
+	//Bytes are gathered per lane until the lane address jumps, then added as one segment
+	pending := make([]gohex.DataSegment, layout.lanes)
+	flush := func(lane uint64) error {
+		if len(pending[lane].Data) == 0 {
+			return nil
+		}
+		err := lanes[lane].AddBinary(pending[lane].Address, pending[lane].Data)
+		pending[lane] = gohex.DataSegment{}
+		return err
+	}
+	for _, segment := range mem.GetDataSegments() {
+		address := uint64(segment.Address)
+		for offset := 0; offset < len(segment.Data); {
+			//Copy up to the end of the current word in one go
+			lane := (address / width) % uint64(layout.lanes)
+			laneAddress := uint32((address/stride)*width + address%width)
+			length := int(width - address%width)
+			if length > len(segment.Data)-offset {
+				length = len(segment.Data) - offset
+			}
+			if pending[lane].Address+uint32(len(pending[lane].Data)) != laneAddress {
+				if err := flush(lane); err != nil {
+					return nil, err
+				}
+				pending[lane].Address = laneAddress
+			}
+			pending[lane].Data = append(pending[lane].Data, segment.Data[offset:offset+length]...)
+			offset += length
+			address += uint64(length)
+		}
+	}
+	for lane := range pending {
+		if err := flush(uint64(lane)); err != nil {
+			return nil, err
+		}
+	}
+	return lanes, nil
+}
+
+//combineLanes is the reverse of splitLanes, each memory in order is one lane of the combined image
+func combineLanes(lanes []*gohex.Memory, layout laneLayout) (*gohex.Memory, error) {
+	if len(lanes) != int(layout.lanes) {
+		return nil, fmt.Errorf("have %d inputs for %d lanes", len(lanes), layout.lanes)
+	}
+	width := uint64(layout.width)
+	combinedAddress := func(lane int, laneAddress uint64) uint64 {
+		return ((laneAddress/width)*uint64(layout.lanes)+uint64(lane))*width + laneAddress%width
+	}
+	//Work out the combined spans each lane touches, then merge those into non overlapping buffers
+	spans := []addressRange{}
+	for lane, mem := range lanes {
+		for _, segment := range mem.GetDataSegments() {
+			first := uint64(segment.Address)
+			last := first + uint64(len(segment.Data)) - 1
+			span := addressRange{start: combinedAddress(lane, first), end: combinedAddress(lane, last) + 1}
+			if span.end > 1<<32 {
+				return nil, fmt.Errorf("lane %d segment @ 0x%08X lands outside of the address space", lane, segment.Address)
+			}
+			spans = append(spans, span)
+		}
+	}
+	merged := unionRanges(spans)
+	buffers := make([][]byte, len(merged))
+	present := make([][]bool, len(merged))
+	for i, span := range merged {
+		buffers[i] = make([]byte, span.end-span.start)
+		present[i] = make([]bool, span.end-span.start)
+	}
+	for lane, mem := range lanes {
+		for _, segment := range mem.GetDataSegments() {
+			start := combinedAddress(lane, uint64(segment.Address))
+			i := sort.Search(len(merged), func(i int) bool { return merged[i].end > start })
+			for offset, b := range segment.Data {
+				address := combinedAddress(lane, uint64(segment.Address)+uint64(offset)) - merged[i].start
+				buffers[i][address] = b
+				present[i][address] = true
+			}
+		}
+	}
+	combined := gohex.NewMemory()
+	for i, span := range merged {
+		//The buffers have holes wherever a lane had no data, only add the runs that were filled in
+		for start := 0; start < len(buffers[i]); {
+			if !present[i][start] {
+				start++
+				continue
+			}
+			end := start
+			for end < len(buffers[i]) && present[i][end] {
+				end++
+			}
+			if err := combined.AddBinary(uint32(span.start)+uint32(start), buffers[i][start:end]); err != nil {
+				return nil, err
+			}
+			start = end
+		}
+	}
+	return combined, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestParseLaneOption(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		value   string
+		want    laneLayout
+		wantErr bool
+	}{
+		{"2", laneLayout{2, 1}, false},
+		{"2:2", laneLayout{2, 2}, false},
+		{"4:0x4", laneLayout{4, 4}, false},
+		{"64", laneLayout{64, 1}, false},
+		{"1", laneLayout{}, true},
+		{"65", laneLayout{}, true},
+		{"100000000", laneLayout{}, true},
+		{"2:0", laneLayout{}, true},
+		{"x", laneLayout{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			layout, err := parseLaneOption(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if layout != tt.want {
+				t.Errorf("got %v, want %v", layout, tt.want)
+			}
+		})
+	}
+}
+
+func TestLaneOutputPath(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		path string
+		lane int
+		want string
+	}{
+		{"out.bin", 0, "out.lane0.bin"},
+		{"out.hex", 3, "out.lane3.hex"},
+		{"dir/out.bin:0x100", 1, "dir/out.lane1.bin:0x100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := laneOutputPath(tt.path, tt.lane); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitLanes(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x100, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	lanes, err := splitLanes(mem, laneLayout{2, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{{0, 1, 4, 5, 8, 9}, {2, 3, 6, 7}}
+	for i, lane := range lanes {
+		segments := lane.GetDataSegments()
+		if len(segments) != 1 || segments[0].Address != 0x80 || !reflect.DeepEqual(segments[0].Data, want[i]) {
+			t.Errorf("lane %d got %v, want %v", i, segments, want[i])
+		}
+	}
+}
+
+func TestLanesRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, layout := range []laneLayout{{2, 1}, {2, 2}, {4, 1}, {3, 4}} {
+		t.Run(fmt.Sprintf("%v", layout), func(t *testing.T) {
+			mem := gohex.NewMemory()
+			//Two segments with an odd start and length, so lanes end up with partial words
+			for _, address := range []uint32{0x1001, 0x8000} {
+				data := make([]byte, 1023)
+				_, err := rand.Read(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mem.AddBinary(address, data)
+			}
+			lanes, err := splitLanes(mem, layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			combined, err := combineLanes(lanes, layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(combined.GetDataSegments(), mem.GetDataSegments()) {
+				t.Error("Combining split lanes should give the original image")
+			}
+		})
+	}
+}
+
+func TestCombineLanesCount(t *testing.T) {
+	t.Parallel()
+	_, err := combineLanes([]*gohex.Memory{gohex.NewMemory()}, laneLayout{2, 1})
+	if err == nil {
+		t.Error("Should raise error when inputs don't match lanes")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//writeHexPreserving writes the image as hex following the record layout of an existing hex file, so diffs only show real changes
+//Unchanged records are copied as they were, changed ones are rewritten in place, removed data is dropped
+//and data the template doesn't have is added before its end of file record
+//The template is read by the caller so the output can replace it
+func writeHexPreserving(w io.Writer, mem *gohex.Memory, template io.Reader, templatePath string) error {
+	h := newHexWriter(w)
+	reader := bufio.NewReader(template)
+	covered := []addressRange{}
+	extended := uint32(0)
+	firstEnding := ""
+	eof := false
+	kept, rewritten := 0, 0
+	for line := 1; !eof; line++ {
+		raw, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if raw == "" {
+			break
+		}
+		text := strings.TrimRight(raw, "\r\n")
+		if firstEnding == "" {
+			firstEnding = raw[len(text):]
+		}
+		record := hexRecord{recordType: 0xFF}
+		if strings.TrimSpace(text) != "" {
+			if record, err = parseHexRecord(strings.TrimSpace(text), line); err != nil {
+				return fmt.Errorf("layout template %s => %v", templatePath, err)
+			}
+		}
+		switch record.recordType {
+		case hexSegmentRecord, hexLinearRecord:
+			shift := uint(4)
+			if record.recordType == hexLinearRecord {
+				shift = 16
+			}
+			extended = uint32(record.data[0])<<(shift+8) | uint32(record.data[1])<<shift
+		case hexEOFRecord:
+			//Anything new goes just before the end of the file
+			if firstEnding != "" {
+				h.ending = firstEnding
+			}
+			if err := writeUncovered(h, mem, covered); err != nil {
+				return err
+			}
+			eof = true
+		case hexDataRecord:
+			r := addressRange{start: uint64(extended) + uint64(record.address), end: uint64(extended) + uint64(record.address) + uint64(len(record.data))}
+			covered = append(covered, r)
+			gaps := memoryGaps(mem, r)
+			if len(gaps) > 0 || !bytes.Equal(readMemory(mem, r, 0), record.data) {
+				//Rewrite whatever is left of the record at its original place in the file
+				rewritten++
+				h.ending = raw[len(text):]
+				cursor := r.start
+				for _, gap := range append(gaps, addressRange{start: r.end, end: r.end}) {
+					if gap.start > cursor {
+						data := readMemory(mem, addressRange{start: cursor, end: gap.start}, 0)
+						if err := h.writeRecord(record.address+uint16(cursor-r.start), hexDataRecord, data); err != nil {
+							return err
+						}
+					}
+					cursor = gap.end
+				}
+				continue
+			}
+			kept++
+		}
+		if _, err := h.w.WriteString(raw); err != nil {
+			return err
+		}
+	}
+	if !eof {
+		return fmt.Errorf("layout template %s has no end of file record", templatePath)
+	}
+	//Copy anything after the end of file record as it was
+	if _, err := io.Copy(h.w, reader); err != nil {
+		return err
+	}
+	fmt.Printf("Kept %d records and rewrote %d from %s\n", kept, rewritten, templatePath)
+	return h.w.Flush()
+}
+
+//writeUncovered writes the data outside the covered ranges as new records
+func writeUncovered(h *hexWriter, mem *gohex.Memory, covered []addressRange) error {
+	covered = unionRanges(covered)
+	for _, segment := range mem.GetDataSegments() {
+		r := addressRange{start: uint64(segment.Address), end: uint64(segment.Address) + uint64(len(segment.Data))}
+		cursor := r.start
+		for _, c := range covered {
+			if c.end <= cursor {
+				continue
+			}
+			if c.start >= r.end {
+				break
+			}
+			if c.start > cursor {
+				if err := h.write(uint32(cursor), segment.Data[cursor-r.start:c.start-r.start]); err != nil {
+					return err
+				}
+			}
+			cursor = c.end
+		}
+		if cursor < r.end {
+			if err := h.write(uint32(cursor), segment.Data[cursor-r.start:]); err != nil {
+				return err
+			}
+		}
+	}
+	return h.flushLine()
+}
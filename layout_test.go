@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestWriteHexPreserving(t *testing.T) {
+	t.Parallel()
+	template := strings.Join([]string{
+		hexLine(0, hexLinearRecord, 0x08, 0x00),
+		strings.ToLower(hexLine(0x0000, hexDataRecord, 1, 2, 3, 4)),
+		hexLine(0x0004, hexDataRecord, 5, 6, 7, 8),
+		"",
+		hexLine(0x0008, hexDataRecord, 9, 10, 11, 12),
+		hexLine(0, hexStartRecord, 0x08, 0, 1, 1),
+		hexLine(0, hexEOFRecord),
+	}, "\r\n") + "\r\n"
+	load := func(text string) *gohex.Memory {
+		mem := gohex.NewMemory()
+		if err := mem.ParseIntelHex(strings.NewReader(text)); err != nil {
+			t.Fatal(err)
+		}
+		return mem
+	}
+
+	//Unchanged data gives back the template exactly
+	var out bytes.Buffer
+	if err := writeHexPreserving(&out, load(template), strings.NewReader(template), "template.hex"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != template {
+		t.Errorf("got\n%q\nwant\n%q", out.String(), template)
+	}
+
+	//Change one byte, drop two and add data elsewhere
+	mem := load(template)
+	mem.SetBinary(0x08000005, []byte{0x66})
+	mem.RemoveBinary(0x08000009, 2)
+	mem.AddBinary(0x08010000, []byte{0xAA})
+	out.Reset()
+	if err := writeHexPreserving(&out, mem, strings.NewReader(template), "template.hex"); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		hexLine(0, hexLinearRecord, 0x08, 0x00),
+		strings.ToLower(hexLine(0x0000, hexDataRecord, 1, 2, 3, 4)),
+		hexLine(0x0004, hexDataRecord, 5, 0x66, 7, 8),
+		"",
+		hexLine(0x0008, hexDataRecord, 9),
+		hexLine(0x000B, hexDataRecord, 12),
+		hexLine(0, hexStartRecord, 0x08, 0, 1, 1),
+		hexLine(0, hexLinearRecord, 0x08, 0x01),
+		hexLine(0x0000, hexDataRecord, 0xAA),
+		hexLine(0, hexEOFRecord),
+	}, "\r\n") + "\r\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+	if got := load(out.String()); !reflect.DeepEqual(got.GetDataSegments(), mem.GetDataSegments()) {
+		t.Errorf("Output does not load back to the same image")
+	}
+
+	if err := writeHexPreserving(&out, mem, strings.NewReader(hexLine(0, hexDataRecord, 1)), "broken.hex"); err == nil {
+		t.Error("Should raise error on a template without an end of file record")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+//lostRecord is a line --lenient skipped and the data it held
+type lostRecord struct {
+	line     int
+	message  string
+	lost     addressRange
+	known    bool //If the line was unreadable the loss is somewhere in the gap between the good data either side
+	address  bool //An extended address record was lost, so later data may be in the wrong place
+	conflict bool //The record overlapped data already loaded, which was kept in its place
+}
+
+func (r lostRecord) String() string {
+	switch {
+	case r.address:
+		return fmt.Sprintf("line %d: %s, lost an extended address so the data after it may be misplaced", r.line, r.message)
+	case r.conflict:
+		return fmt.Sprintf("line %d: %s, kept the data already loaded in %v", r.line, r.message, r.lost)
+	case r.known:
+		return fmt.Sprintf("line %d: %s, lost %v", r.line, r.message, r.lost)
+	case r.lost.end > r.lost.start:
+		return fmt.Sprintf("line %d: %s, lost data somewhere in %v", r.line, r.message, r.lost)
+	}
+	return fmt.Sprintf("line %d: %s, lost data at an unknown address", r.line, r.message)
+}
+
+//loadHexLenient loads every record of a hex file that passes the lint checks into the image, skipping the rest
+func loadHexLenient(path string, img writableImage) ([]lostRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lost []lostRecord
+	var writeErr error
+	pending := []int{} //Unreadable lines waiting for the next good data to bound them
+	lastEnd := uint64(0)
+	l := newHexLinter()
+	l.data = func(line int, address uint32, data []byte) {
+		for _, i := range pending {
+			if uint64(address) >= lost[i].lost.start {
+				lost[i].lost.end = uint64(address)
+			}
+		}
+		pending = pending[:0]
+		lastEnd = uint64(address) + uint64(len(data))
+		if err := img.write(address, data); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+	l.start = img.setStartAddress
+	l.rejected = func(line int, decoded []byte, message string) {
+		record := lostRecord{line: line, message: message}
+		if len(decoded) >= 4 && !l.eof {
+			switch decoded[3] {
+			case hexDataRecord:
+				start := uint64(l.extended) + uint64(binary.BigEndian.Uint16(decoded[1:]))
+				record.lost, record.known = addressRange{start: start, end: start + uint64(decoded[0])}, true
+				gaps := imageGaps(img, record.lost)
+				record.conflict = len(gaps) != 1 || gaps[0] != record.lost
+				lost = append(lost, record)
+				return
+			case hexSegmentRecord, hexLinearRecord:
+				record.address = true
+				lost = append(lost, record)
+				return
+			case hexEOFRecord, 0x03, hexStartRecord:
+				return //Nothing lost from the image
+			}
+		}
+		record.lost = addressRange{start: lastEnd, end: lastEnd}
+		pending = append(pending, len(lost))
+		lost = append(lost, record)
+	}
+	if _, err := lintHex(file, l); err != nil {
+		return nil, err
+	}
+	return lost, writeErr
+}
+
+//loadImageLenient is loadImage for --lenient, hex files skip bad records and report what was lost
+func loadImageLenient(path string, store *pageStore) (*pagedImage, error) {
+	isHex, _, filteredPath, err := parseFileTypeAndStart(path)
+	if err != nil || !isHex {
+		return loadImage(path, store)
+	}
+	img := newPagedImage(store)
+	lost, err := loadHexLenient(filteredPath, img)
+	if err != nil {
+		return img, err
+	}
+	if len(lost) == 0 {
+		fmt.Printf("No records skipped in %s\n", filteredPath)
+		return img, nil
+	}
+	fmt.Printf("Skipped %d records of %s:\n", len(lost), filteredPath)
+	ranges := []addressRange{}
+	for _, record := range lost {
+		fmt.Printf("  %v\n", record)
+		switch {
+		case record.conflict:
+			//Only the part of a conflicting record without data from elsewhere is missing
+			ranges = append(ranges, imageGaps(img, record.lost)...)
+		case record.lost.end > record.lost.start:
+			ranges = append(ranges, record.lost)
+		}
+	}
+	if ranges = unionRanges(ranges); len(ranges) > 0 {
+		fmt.Printf("Data missing from %v\n", ranges)
+	}
+	return img, nil
+}
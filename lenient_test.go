@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestLoadHexLenient(t *testing.T) {
+	t.Parallel()
+	corrupt := func(line string) string { return line[:len(line)-2] + "00" }
+	lines := []string{
+		hexLine(0, hexLinearRecord, 0x08, 0x00),
+		hexLine(0x0000, hexDataRecord, 1, 2, 3, 4),
+		corrupt(hexLine(0x0004, hexDataRecord, 5, 6, 7, 8)),
+		hexLine(0x0008, hexDataRecord, 9, 10, 11, 12),
+		":0400!!",
+		hexLine(0x0010, hexDataRecord, 17, 18, 19, 20),
+		hexLine(0x0012, hexDataRecord, 1, 1), //Overlaps the line before
+		corrupt(hexLine(0, hexLinearRecord, 0x09, 0x00)),
+		hexLine(0x0020, hexDataRecord, 33),
+		hexLine(0x0030, hexDataRecord, 1, 2, 3, 4),
+		hexLine(0x0032, hexDataRecord, 1, 2, 3, 4), //Half overlaps the line before
+		hexLine(0, 0x03, 0x12, 0x34, 0x00, 0x10),   //CS:IP is ignored, as the strict loader does
+		hexLine(0, hexStartRecord, 0x08, 0x00, 0x00, 0x01),
+		hexLine(0, hexStartRecord, 0x08, 0x00, 0x00, 0x05), //Only the first start address counts
+		hexLine(0, hexEOFRecord),
+	}
+	file, err := os.CreateTemp("", "test_*.hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(strings.Join(lines, "\n") + "\n")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	mem := gohex.NewMemory()
+	lost, err := loadHexLenient(file.Name(), memoryImage{mem})
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := mem.GetDataSegments()
+	wantAddresses := []uint32{0x08000000, 0x08000008, 0x08000010, 0x08000020, 0x08000030}
+	if len(segments) != len(wantAddresses) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(wantAddresses))
+	}
+	for i, segment := range segments {
+		if segment.Address != wantAddresses[i] {
+			t.Errorf("segment %d @ 0x%08X, want 0x%08X", i, segment.Address, wantAddresses[i])
+		}
+	}
+	if start, ok := mem.GetStartAddress(); !ok || start != 0x08000001 {
+		t.Errorf("got start address 0x%08X %v, want 0x08000001", start, ok)
+	}
+	want := []lostRecord{
+		{line: 3, lost: addressRange{0x08000004, 0x08000008}, known: true},
+		{line: 5, lost: addressRange{0x0800000C, 0x08000010}},
+		{line: 7, lost: addressRange{0x08000012, 0x08000014}, known: true, conflict: true},
+		{line: 8, address: true},
+		{line: 11, lost: addressRange{0x08000032, 0x08000036}, known: true, conflict: true},
+	}
+	if len(lost) != len(want) {
+		t.Fatalf("got %v, want %v", lost, want)
+	}
+	for i := range lost {
+		lost[i].message = ""
+		if !reflect.DeepEqual(lost[i], want[i]) {
+			t.Errorf("got %+v, want %+v", lost[i], want[i])
+		}
+	}
+}
+
+func TestParseInputFileLenient(t *testing.T) {
+	t.Parallel()
+	binFile, hexFile := createTestFilePair(t, 64, 0)
+	defer os.Remove(binFile)
+	defer os.Remove(hexFile)
+	startFile, err := os.CreateTemp("", "*_start.hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(startFile.Name())
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x08000000, []byte{1, 2, 3, 4})
+	mem.SetStartAddress(0x08000101)
+	writeHex(startFile, memoryImage{mem})
+	startFile.Close()
+	csipFile, err := os.CreateTemp("", "*_csip.hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(csipFile.Name())
+	csipFile.WriteString(strings.Join([]string{hexLine(0x100, hexDataRecord, 1, 2), hexLine(0, 0x03, 0x12, 0x34, 0x00, 0x10), hexLine(0, hexEOFRecord)}, "\n") + "\n")
+	csipFile.Close()
+	store, err := newPageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	//Clean files load the same as without --lenient, and the same paged as into a gohex.Memory
+	for _, path := range []string{binFile + ":0x100", hexFile, startFile.Name(), csipFile.Name()} {
+		strict, err := parseInputFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, load := range []func(string, *pageStore) (*pagedImage, error){loadImage, loadImageLenient} {
+			img, err := load(path, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := toMemory(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(strict.GetDataSegments(), loaded.GetDataSegments()) {
+				t.Errorf("%s loads differently paged", path)
+			}
+			strictStart, strictOK := strict.GetStartAddress()
+			loadedStart, loadedOK := loaded.GetStartAddress()
+			if strictStart != loadedStart || strictOK != loadedOK {
+				t.Errorf("%s start address 0x%08X %v paged, want 0x%08X %v", path, loadedStart, loadedOK, strictStart, strictOK)
+			}
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+//lintProblem is one thing wrong with a hex file, line 0 is the file as a whole
+type lintProblem struct {
+	line    int
+	warning bool
+	message string
+}
+
+func (p lintProblem) String() string {
+	level := "error"
+	if p.warning {
+		level = "warning"
+	}
+	if p.line == 0 {
+		return fmt.Sprintf("%s: %s", level, p.message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", p.line, level, p.message)
+}
+
+//hexLinter checks the records of a hex file one line at a time
+type hexLinter struct {
+	problems []lintProblem
+	line     int
+	//Warnings that would repeat on every line are reported once at the first line with a count of the rest
+	repeated map[string]*lintProblem
+	counts   map[string]int
+	order    []string //Repeated messages in the order first seen, so output doesn't depend on map order
+	extended uint32
+	segment  bool //The last extended address was a 02 segment record so addresses wrap within 64K
+	eof      bool
+	started  bool
+	written  rangeSet
+	//A data record shorter than usual is only a problem if the next one carries on from it
+	recordLength int
+	runEnd       uint64
+	short        *hexRecord
+	shortEnd     uint64
+	ending       string
+	errors       int
+	decoded      []byte //The current line's bytes, once they are known to be hex
+	accepted     bool   //The current line's data passed every check
+	//data is called with every data record that passes all the checks
+	data func(line int, address uint32, data []byte)
+	//rejected is called after a line with an error, with the bytes of the line if it was hex
+	rejected func(line int, decoded []byte, message string)
+	//start is called with the first type 05 start address, 03 CS:IP records are ignored as gohex does
+	start       func(address uint32)
+	linearStart bool
+}
+
+func newHexLinter() *hexLinter {
+	return &hexLinter{repeated: map[string]*lintProblem{}, counts: map[string]int{}}
+}
+
+func (l *hexLinter) report(warning bool, format string, args ...interface{}) {
+	if !warning {
+		l.errors++
+	}
+	l.problems = append(l.problems, lintProblem{line: l.line, warning: warning, message: fmt.Sprintf(format, args...)})
+}
+
+func (l *hexLinter) reportRepeated(message string) {
+	if _, ok := l.repeated[message]; !ok {
+		l.repeated[message] = &lintProblem{line: l.line, warning: true, message: message}
+		l.order = append(l.order, message)
+		return
+	}
+	l.counts[message]++
+}
+
+//lintLine checks one line, including its line ending
+func (l *hexLinter) lintLine(raw string) {
+	errors := l.errors
+	l.decoded, l.accepted = nil, false
+	l.checkLine(raw)
+	if l.rejected != nil && l.errors != errors && !l.accepted {
+		message := ""
+		for i := len(l.problems) - 1; i >= 0 && message == ""; i-- {
+			if !l.problems[i].warning {
+				message = l.problems[i].message
+			}
+		}
+		l.rejected(l.line, l.decoded, message)
+	}
+}
+
+func (l *hexLinter) checkLine(raw string) {
+	l.line++
+	text := strings.TrimSuffix(raw, "\n")
+	ending := "\n"
+	if !strings.HasSuffix(raw, "\n") {
+		ending = ""
+	} else if strings.HasSuffix(text, "\r") {
+		text = strings.TrimSuffix(text, "\r")
+		ending = "\r\n"
+	}
+	if ending != "" {
+		if l.ending == "" {
+			l.ending = ending
+		} else if ending != l.ending {
+			l.reportRepeated(fmt.Sprintf("line ending %q differs from the first line's %q", ending, l.ending))
+		}
+	}
+	if strings.TrimSpace(text) != text {
+		l.reportRepeated("whitespace around the record")
+		text = strings.TrimSpace(text)
+	}
+	if text == "" {
+		return
+	}
+	if l.eof {
+		l.report(false, "record after the end of file record")
+		return
+	}
+	if text[0] != ':' {
+		l.report(false, "record does not start with ':'")
+		return
+	}
+	if strings.ToUpper(text) != text {
+		l.reportRepeated("lowercase hex digits")
+	}
+	if len(text)%2 == 0 {
+		l.report(false, "odd number of hex digits")
+		return
+	}
+	decoded, err := hex.DecodeString(text[1:])
+	if err != nil {
+		l.report(false, "not hex => %v", err)
+		return
+	}
+	l.decoded = decoded
+	if len(decoded) < 5 {
+		l.report(false, "record is only %d bytes, the shortest is 5", len(decoded))
+		return
+	}
+	if int(decoded[0])+5 != len(decoded) {
+		l.report(false, "byte count says %d data bytes but the record holds %d", decoded[0], len(decoded)-5)
+		return
+	}
+	sum := byte(0)
+	for _, b := range decoded[:len(decoded)-1] {
+		sum += b
+	}
+	if -sum != decoded[len(decoded)-1] {
+		l.report(false, "checksum is 0x%02X, should be 0x%02X", decoded[len(decoded)-1], -sum)
+		return
+	}
+	l.lintRecord(hexRecord{line: l.line, address: binary.BigEndian.Uint16(decoded[1:]), recordType: decoded[3], data: decoded[4 : len(decoded)-1]})
+}
+
+//lintRecord checks a record that parsed, against the records before it
+func (l *hexLinter) lintRecord(record hexRecord) {
+	switch record.recordType {
+	case hexDataRecord:
+		l.lintData(record)
+	case hexEOFRecord:
+		if len(record.data) != 0 {
+			l.report(false, "end of file record has %d data bytes", len(record.data))
+		}
+		l.eof = true
+	case hexSegmentRecord, hexLinearRecord:
+		if len(record.data) != 2 {
+			l.report(false, "extended address record has %d data bytes, should be 2", len(record.data))
+			return
+		}
+		if record.address != 0 {
+			l.report(false, "extended address record has address 0x%04X, should be 0", record.address)
+			return
+		}
+		value := uint32(binary.BigEndian.Uint16(record.data))
+		l.segment = record.recordType == hexSegmentRecord
+		if l.segment {
+			l.extended = value << 4
+		} else {
+			l.extended = value << 16
+		}
+	case 0x03, hexStartRecord:
+		if len(record.data) != 4 {
+			l.report(false, "start address record has %d data bytes, should be 4", len(record.data))
+			return
+		}
+		if record.recordType == hexStartRecord && !l.linearStart {
+			l.linearStart = true
+			if l.start != nil {
+				l.start(binary.BigEndian.Uint32(record.data))
+			}
+		}
+		if l.started {
+			l.report(false, "more than one start address record")
+			return
+		}
+		l.started = true
+	default:
+		l.report(false, "unknown record type 0x%02X", record.recordType)
+	}
+}
+
+func (l *hexLinter) lintData(record hexRecord) {
+	start := uint64(l.extended) + uint64(record.address)
+	end := start + uint64(len(record.data))
+	if len(record.data) == 0 {
+		l.report(true, "data record with no data")
+		return
+	}
+	if uint64(record.address)+uint64(len(record.data)) > 0x10000 {
+		kind := "linear"
+		if l.segment {
+			kind = "segment"
+		}
+		l.report(true, "data wraps past the end of the 64K %s @ 0x%08X, readers disagree on where it goes", kind, l.extended)
+	}
+	if end > 1<<32 {
+		l.report(false, "data runs past the end of the address space")
+		return
+	}
+	//Within a run of contiguous records every record should be as long as the first, except the last
+	//and any that stop at a 64K boundary, which writers split records on
+	if l.short != nil && start == l.shortEnd {
+		l.problems = append(l.problems, lintProblem{line: l.short.line, warning: true,
+			message: fmt.Sprintf("record holds %d bytes where the others hold %d", len(l.short.data), l.recordLength)})
+	}
+	if start != l.runEnd {
+		l.recordLength = 0
+	}
+	l.short, l.runEnd = nil, end
+	if end%0x10000 != 0 {
+		if l.recordLength == 0 {
+			l.recordLength = len(record.data)
+		} else if len(record.data) > l.recordLength {
+			l.report(true, "record holds %d bytes where the others hold %d", len(record.data), l.recordLength)
+		} else if len(record.data) < l.recordLength {
+			l.short, l.shortEnd = &record, end
+		}
+	}
+	if !l.written.add(addressRange{start: start, end: end}) {
+		l.report(false, "data 0x%08X-0x%08X overlaps an earlier record", start, end)
+		return
+	}
+	l.accepted = true
+	if l.data != nil {
+		l.data(l.line, uint32(start), record.data)
+	}
+}
+
+//finish reports problems with the file as a whole, returning every problem sorted by line
+func (l *hexLinter) finish() []lintProblem {
+	if !l.eof {
+		l.problems = append(l.problems, lintProblem{message: "no end of file record"})
+	}
+	for _, message := range l.order {
+		problem := l.repeated[message]
+		if count := l.counts[message]; count > 0 {
+			problem.message = fmt.Sprintf("%s, and on %d more lines", problem.message, count)
+		}
+		l.problems = append(l.problems, *problem)
+	}
+	sort.SliceStable(l.problems, func(i, j int) bool { return l.problems[i].line < l.problems[j].line })
+	return l.problems
+}
+
+//lintHex checks every line of a hex file
+func lintHex(r io.Reader, l *hexLinter) ([]lintProblem, error) {
+	reader := bufio.NewReader(r)
+	for {
+		raw, err := reader.ReadString('\n')
+		if len(raw) > 0 {
+			l.lintLine(raw)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l.finish(), nil
+}
+
+//runLint implements `hexm lint file.hex... [--strict]`, failing on errors or with --strict on warnings too
+func runLint(args []string) error {
+	options, files := splitOptions(args)
+	strict := false
+	for _, opt := range options {
+		switch opt.name {
+		case "strict":
+			strict = true
+		default:
+			return fmt.Errorf("unknown option --%s", opt.name)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no file to lint")
+	}
+	errors, warnings := 0, 0
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		problems, err := lintHex(file, newHexLinter())
+		file.Close()
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %v\n", path, problem)
+			if problem.warning {
+				warnings++
+			} else {
+				errors++
+			}
+		}
+	}
+	fmt.Printf("%d errors, %d warnings\n", errors, warnings)
+	if errors > 0 || (strict && warnings > 0) {
+		return fmt.Errorf("lint failed")
+	}
+	return nil
+}
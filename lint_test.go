@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+//hexLine builds a record with a correct checksum
+func hexLine(address uint16, recordType byte, data ...byte) string {
+	record := append([]byte{byte(len(data)), byte(address >> 8), byte(address), recordType}, data...)
+	sum := byte(0)
+	for _, b := range record {
+		sum += b
+	}
+	return ":" + strings.ToUpper(hex.EncodeToString(append(record, -sum)))
+}
+
+func TestLintHexRepeatedOrder(t *testing.T) {
+	t.Parallel()
+	text := " " + strings.ToLower(hexLine(0, hexDataRecord, 0xAB)) + "\n " + strings.ToLower(hexLine(1, hexDataRecord, 0xCD)) + "\n" + hexLine(0, hexEOFRecord) + "\n"
+	//Warnings first seen on the same line should always come out in the order they were found
+	for i := 0; i < 20; i++ {
+		problems, err := lintHex(strings.NewReader(text), newHexLinter())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 2 || !strings.HasPrefix(problems[0].message, "whitespace") || !strings.HasPrefix(problems[1].message, "lowercase") {
+			t.Fatalf("got %v", problems)
+		}
+	}
+}
+
+func TestLintHex(t *testing.T) {
+	t.Parallel()
+	eof := hexLine(0, hexEOFRecord)
+	sixteen := make([]byte, 16)
+	var tests = []struct {
+		name  string
+		lines []string
+		want  []string //Expected problems as "line:level:start of message"
+	}{
+		{"clean", []string{hexLine(0, hexLinearRecord, 0x08, 0x00), hexLine(0, hexDataRecord, sixteen...), hexLine(0x10, hexDataRecord, 1, 2), eof}, nil},
+		{"bad checksum", []string{strings.Replace(hexLine(0, hexDataRecord, 1), "FE", "FF", 1), eof}, []string{"1:error:checksum"}},
+		{"byte count", []string{":0200000001FD", eof}, []string{"1:error:byte count"}},
+		{"not hex", []string{":01000000GGFE", eof}, []string{"1:error:not hex"}},
+		{"odd digits", []string{":0100000001F", eof}, []string{"1:error:odd"}},
+		{"no colon", []string{"0100000001FE", eof}, []string{"1:error:record does not start"}},
+		{"missing eof", []string{hexLine(0, hexDataRecord, 1)}, []string{"0:error:no end of file"}},
+		{"after eof", []string{eof, hexLine(0, hexDataRecord, 1)}, []string{"2:error:record after"}},
+		{"overlap", []string{hexLine(0, hexDataRecord, 1, 2), hexLine(1, hexDataRecord, 3), eof}, []string{"2:error:data 0x00000001"}},
+		{"lowercase", []string{strings.ToLower(hexLine(0, hexDataRecord, 0xAB)), strings.ToLower(hexLine(1, hexDataRecord, 0xCD)), eof}, []string{"1:warning:lowercase hex digits, and on 1 more"}},
+		{"repeated order", []string{" " + strings.ToLower(hexLine(0, hexDataRecord, 0xAB)), " " + strings.ToLower(hexLine(1, hexDataRecord, 0xCD)), eof}, []string{"1:warning:whitespace", "1:warning:lowercase"}},
+		{"mixed endings", []string{hexLine(0, hexDataRecord, 1) + "\r", eof}, []string{"2:warning:line ending"}},
+		{"wrap", []string{hexLine(0xFFFF, hexDataRecord, 1, 2), eof}, []string{"1:warning:data wraps"}},
+		{"duplicate start", []string{hexLine(0, hexStartRecord, 0, 0, 1, 0), hexLine(0, 0x03, 0, 0, 1, 0), eof}, []string{"2:error:more than one start"}},
+		{"short then continues", []string{hexLine(0, hexDataRecord, sixteen...), hexLine(0x10, hexDataRecord, 1, 2), hexLine(0x12, hexDataRecord, sixteen...), eof}, []string{"2:warning:record holds 2"}},
+		{"longer", []string{hexLine(0, hexDataRecord, 1, 2), hexLine(2, hexDataRecord, sixteen...), eof}, []string{"2:warning:record holds 16"}},
+		{"bad extended", []string{hexLine(0, hexLinearRecord, 1), hexLine(0, hexSegmentRecord, 1, 2, 3), eof}, []string{"1:error:extended", "2:error:extended"}},
+		{"unknown type", []string{hexLine(0, 0x07), eof}, []string{"1:error:unknown record type"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := lintHex(strings.NewReader(strings.Join(tt.lines, "\n")+"\n"), newHexLinter())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != len(tt.want) {
+				t.Fatalf("got %v, want %v", problems, tt.want)
+			}
+			for i, problem := range problems {
+				level := "error"
+				if problem.warning {
+					level = "warning"
+				}
+				got := fmt.Sprintf("%d:%s:%s", problem.line, level, problem.message)
+				if !strings.HasPrefix(got, tt.want[i]) {
+					t.Errorf("got %s, want %s", got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLintMatchesWriter(t *testing.T) {
+	t.Parallel()
+	//Everything hexm writes should lint clean
+	var out strings.Builder
+	h := newHexWriter(&out)
+	h.writeStart(0x08000101)
+	h.write(0x0800FFF0, make([]byte, 100))
+	h.write(0x90000000, make([]byte, 5))
+	h.close()
+	problems, err := lintHex(strings.NewReader(out.String()), newHexLinter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("got %v", problems)
+	}
+}
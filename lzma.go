@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+//LZMA is written in the .lzma (LZMA alone) format the LZMA SDK decoder expects:
+//properties byte, u32 dictionary size and u64 uncompressed length, all little endian, then the range coded data
+const (
+	lzmaLC          = 3 //Literal context bits
+	lzmaLP          = 0 //Literal position bits
+	lzmaPB          = 2 //Position bits
+	lzmaStates      = 12
+	lzmaMinMatch    = 2
+	lzmaMaxMatch    = 273
+	lzmaProbInit    = 1 << 10
+	lzmaEndPosModel = 14
+	lzmaFullDist    = 1 << (lzmaEndPosModel >> 1)
+	lzmaHashBits    = 16
+	lzmaChainDepth  = 64
+)
+
+type lzmaProb uint16
+
+//lzmaRangeEncoder is the binary arithmetic coder all LZMA symbols go through
+type lzmaRangeEncoder struct {
+	out       []byte
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int
+}
+
+func (e *lzmaRangeEncoder) shiftLow() {
+	if uint32(e.low) < 0xFF000000 || e.low>>32 != 0 {
+		carry := byte(e.low >> 32)
+		temp := e.cache
+		for ; e.cacheSize > 0; e.cacheSize-- {
+			e.out = append(e.out, temp+carry)
+			temp = 0xFF
+		}
+		e.cache = byte(e.low >> 24)
+	}
+	e.cacheSize++
+	e.low = (e.low & 0x00FFFFFF) << 8
+}
+
+func (e *lzmaRangeEncoder) encodeBit(prob *lzmaProb, bit uint32) {
+	bound := (e.rng >> 11) * uint32(*prob)
+	if bit == 0 {
+		e.rng = bound
+		*prob += (1<<11 - *prob) >> 5
+	} else {
+		e.low += uint64(bound)
+		e.rng -= bound
+		*prob -= *prob >> 5
+	}
+	for e.rng < 1<<24 {
+		e.rng <<= 8
+		e.shiftLow()
+	}
+}
+
+func (e *lzmaRangeEncoder) encodeDirect(value uint32, count uint) {
+	for count > 0 {
+		count--
+		e.rng >>= 1
+		if value>>count&1 != 0 {
+			e.low += uint64(e.rng)
+		}
+		for e.rng < 1<<24 {
+			e.rng <<= 8
+			e.shiftLow()
+		}
+	}
+}
+
+func (e *lzmaRangeEncoder) flush() {
+	for i := 0; i < 5; i++ {
+		e.shiftLow()
+	}
+}
+
+//encodeTree codes count bits of symbol most significant first through a tree of probabilities
+func (e *lzmaRangeEncoder) encodeTree(probs []lzmaProb, count uint, symbol uint32) {
+	m := uint32(1)
+	for count > 0 {
+		count--
+		bit := symbol >> count & 1
+		e.encodeBit(&probs[m], bit)
+		m = m<<1 | bit
+	}
+}
+
+//encodeReverseTree codes count bits of symbol least significant first
+func (e *lzmaRangeEncoder) encodeReverseTree(probs []lzmaProb, count uint, symbol uint32) {
+	m := uint32(1)
+	for ; count > 0; count-- {
+		bit := symbol & 1
+		symbol >>= 1
+		e.encodeBit(&probs[m], bit)
+		m = m<<1 | bit
+	}
+}
+
+//lzmaLengthEncoder codes match lengths as 8 low, 8 mid or 256 high values
+type lzmaLengthEncoder struct {
+	choice, choice2 lzmaProb
+	low, mid        [1 << lzmaPB][8]lzmaProb
+	high            [256]lzmaProb
+}
+
+func newLzmaLengthEncoder() *lzmaLengthEncoder {
+	l := &lzmaLengthEncoder{choice: lzmaProbInit, choice2: lzmaProbInit}
+	for i := range l.low {
+		initLzmaProbs(l.low[i][:])
+		initLzmaProbs(l.mid[i][:])
+	}
+	initLzmaProbs(l.high[:])
+	return l
+}
+
+func (l *lzmaLengthEncoder) encode(e *lzmaRangeEncoder, length uint32, posState uint32) {
+	length -= lzmaMinMatch
+	switch {
+	case length < 8:
+		e.encodeBit(&l.choice, 0)
+		e.encodeTree(l.low[posState][:], 3, length)
+	case length < 16:
+		e.encodeBit(&l.choice, 1)
+		e.encodeBit(&l.choice2, 0)
+		e.encodeTree(l.mid[posState][:], 3, length-8)
+	default:
+		e.encodeBit(&l.choice, 1)
+		e.encodeBit(&l.choice2, 1)
+		e.encodeTree(l.high[:], 8, length-16)
+	}
+}
+
+func initLzmaProbs(probs []lzmaProb) {
+	for i := range probs {
+		probs[i] = lzmaProbInit
+	}
+}
+
+func newLzmaProbs(count int) []lzmaProb {
+	probs := make([]lzmaProb, count)
+	initLzmaProbs(probs)
+	return probs
+}
+
+//lzmaPosSlot is the distance slot: the position of the top bit and the bit below it
+func lzmaPosSlot(distance uint32) uint32 {
+	if distance < 4 {
+		return distance
+	}
+	n := uint32(bits.Len32(distance) - 1)
+	return n<<1 | distance>>(n-1)&1
+}
+
+//lzmaCompress compresses data with greedy hash chain matching, repeating the last distance where it can
+func lzmaCompress(data []byte) []byte {
+	dictSize := uint32(1 << 12)
+	for dictSize < uint32(len(data)) && dictSize < 1<<26 {
+		dictSize <<= 1
+	}
+	header := make([]byte, 13)
+	header[0] = (lzmaPB*5+lzmaLP)*9 + lzmaLC
+	binary.LittleEndian.PutUint32(header[1:], dictSize)
+	binary.LittleEndian.PutUint64(header[5:], uint64(len(data)))
+
+	e := &lzmaRangeEncoder{out: header, rng: 0xFFFFFFFF, cacheSize: 1}
+	var (
+		isMatch    = newLzmaProbs(lzmaStates << lzmaPB)
+		isRep      = newLzmaProbs(lzmaStates)
+		isRepG0    = newLzmaProbs(lzmaStates)
+		isRep0Long = newLzmaProbs(lzmaStates << lzmaPB)
+		literals   = newLzmaProbs(0x300 << (lzmaLC + lzmaLP))
+		posSlots   = newLzmaProbs(4 << 6)
+		posSpecial = newLzmaProbs(1 + lzmaFullDist - lzmaEndPosModel) //Offset by one so slot 4 can index from -1
+		align      = newLzmaProbs(1 << 4)
+		lengths    = newLzmaLengthEncoder()
+		repLengths = newLzmaLengthEncoder()
+		state      = uint32(0)
+		rep0       = uint32(0)
+	)
+	const posMask = 1<<lzmaPB - 1
+
+	head := make([]int, 1<<lzmaHashBits)
+	previous := make([]int, len(data))
+	for i := range head {
+		head[i] = -1
+	}
+	hash := func(position int) int {
+		return int((uint32(data[position]) | uint32(data[position+1])<<8 | uint32(data[position+2])<<16) * 2654435761 >> (32 - lzmaHashBits))
+	}
+	insert := func(position int) {
+		if position+2 < len(data) {
+			h := hash(position)
+			previous[position] = head[h]
+			head[h] = position
+		}
+	}
+	matchLength := func(position, candidate int) int {
+		length := 0
+		for length < lzmaMaxMatch && position+length < len(data) && data[candidate+length] == data[position+length] {
+			length++
+		}
+		return length
+	}
+
+	for position := 0; position < len(data); {
+		posState := uint32(position) & posMask
+		//Prefer repeating the last distance, it is cheaper to code than a new one
+		repLength := 0
+		if position > int(rep0) {
+			repLength = matchLength(position, position-int(rep0)-1)
+		}
+		bestLength, bestDistance := 0, uint32(0)
+		if position+2 < len(data) {
+			depth := 0
+			for candidate := head[hash(position)]; candidate >= 0 && uint32(position-candidate) <= dictSize && depth < lzmaChainDepth; candidate = previous[candidate] {
+				depth++
+				if length := matchLength(position, candidate); length > bestLength {
+					bestLength, bestDistance = length, uint32(position-candidate-1)
+					if length == lzmaMaxMatch {
+						break
+					}
+				}
+			}
+		}
+		length := 1
+		switch {
+		case repLength >= lzmaMinMatch && repLength+1 >= bestLength:
+			length = repLength
+			e.encodeBit(&isMatch[state<<lzmaPB+posState], 1)
+			e.encodeBit(&isRep[state], 1)
+			e.encodeBit(&isRepG0[state], 0)
+			e.encodeBit(&isRep0Long[state<<lzmaPB+posState], 1)
+			repLengths.encode(e, uint32(length), posState)
+			if state < 7 {
+				state = 8
+			} else {
+				state = 11
+			}
+		case bestLength >= 3:
+			length = bestLength
+			e.encodeBit(&isMatch[state<<lzmaPB+posState], 1)
+			e.encodeBit(&isRep[state], 0)
+			lengths.encode(e, uint32(length), posState)
+			lengthState := uint32(length - lzmaMinMatch)
+			if lengthState > 3 {
+				lengthState = 3
+			}
+			slot := lzmaPosSlot(bestDistance)
+			e.encodeTree(posSlots[lengthState<<6:(lengthState+1)<<6], 6, slot)
+			if slot >= 4 {
+				footerBits := uint(slot>>1 - 1)
+				base := (2 | slot&1) << footerBits
+				reduced := bestDistance - base
+				if slot < lzmaEndPosModel {
+					e.encodeReverseTree(posSpecial[base-slot:], footerBits, reduced)
+				} else {
+					e.encodeDirect(reduced>>4, footerBits-4)
+					e.encodeReverseTree(align, 4, reduced&15)
+				}
+			}
+			rep0 = bestDistance
+			if state < 7 {
+				state = 7
+			} else {
+				state = 10
+			}
+		default:
+			e.encodeBit(&isMatch[state<<lzmaPB+posState], 0)
+			previousByte := uint32(0)
+			if position > 0 {
+				previousByte = uint32(data[position-1])
+			}
+			probs := literals[0x300*(previousByte>>(8-lzmaLC)):]
+			symbol := uint32(data[position]) | 0x100
+			if state < 7 {
+				for ; symbol < 0x10000; symbol <<= 1 {
+					e.encodeBit(&probs[symbol>>8], symbol>>7&1)
+				}
+			} else {
+				//After a match the byte at the last distance steers the probabilities until it differs
+				matchByte := uint32(data[position-int(rep0)-1])
+				offset := uint32(0x100)
+				for ; symbol < 0x10000; symbol <<= 1 {
+					matchByte <<= 1
+					e.encodeBit(&probs[offset+matchByte&offset+symbol>>8], symbol>>7&1)
+					offset &^= matchByte ^ (symbol << 1)
+				}
+			}
+			switch {
+			case state < 4:
+				state = 0
+			case state < 10:
+				state -= 3
+			default:
+				state -= 6
+			}
+		}
+		for end := position + length; position < end; position++ {
+			insert(position)
+		}
+	}
+	e.flush()
+	return e.out
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os/exec"
+	"testing"
+)
+
+func TestLzmaPosSlot(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		distance uint32
+		slot     uint32
+	}{
+		{0, 0}, {3, 3}, {4, 4}, {5, 4}, {6, 5}, {7, 5}, {8, 6}, {12, 7}, {0xFFFFFFFF, 63},
+	}
+	for _, tt := range tests {
+		if slot := lzmaPosSlot(tt.distance); slot != tt.slot {
+			t.Errorf("distance %d got slot %d, want %d", tt.distance, slot, tt.slot)
+		}
+	}
+}
+
+func TestLzmaCompress(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz is needed to decompress")
+	}
+	noise := make([]byte, 20000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	//Long distances and lengths need the direct bits, align and high length coders
+	long := append(append(append([]byte{}, noise...), bytes.Repeat([]byte{0}, 1000)...), noise...)
+	for name, data := range map[string][]byte{
+		"empty": {},
+		"byte":  {0x42},
+		"mixed": compressTestData(),
+		"long":  long,
+	} {
+		compressed := lzmaCompress(data)
+		cmd := exec.Command("xz", "--format=lzma", "-dc")
+		cmd.Stdin = bytes.NewReader(compressed)
+		decoded, err := cmd.Output()
+		if err != nil {
+			t.Errorf("%s: xz could not decompress => %v", name, err)
+			continue
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("%s: round trip does not match", name)
+		}
+	}
+}
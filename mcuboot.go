@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//Constants from the MCUboot image format (bootutil/image.h)
+const (
+	mcubootImageMagic   = 0x96f3b83d
+	mcubootTLVInfoMagic = 0x6907
+	mcubootTLVSHA256    = 0x10
+	mcubootHeaderBytes  = 32
+)
+
+//mcubootSettings are shared by every --mcuboot, so the --mcuboot-* options apply wherever they are given
+type mcubootSettings struct {
+	headerSize  uint32
+	loadAddress uint32
+	flags       uint32
+	version     mcubootVersion
+	fill        byte //Value used for gaps inside the payload
+}
+
+type mcubootVersion struct {
+	major    uint8
+	minor    uint8
+	revision uint16
+	build    uint32
+}
+
+//parseMcubootVersion parses MAJOR.MINOR.REVISION+BUILD, later parts are optional as with imgtool
+func parseMcubootVersion(data string) (mcubootVersion, error) {
+	version := mcubootVersion{}
+	parts := strings.SplitN(data, "+", 2)
+	numbers := strings.Split(parts[0], ".")
+	if len(numbers) > 3 {
+		return version, fmt.Errorf("version %s should be MAJOR.MINOR.REVISION+BUILD", data)
+	}
+	limits := []int{8, 8, 16}
+	values := []uint64{0, 0, 0}
+	for i, number := range numbers {
+		n, err := strconv.ParseUint(number, 10, limits[i])
+		if err != nil {
+			return version, fmt.Errorf("version %s => %v", data, err)
+		}
+		values[i] = n
+	}
+	version.major, version.minor, version.revision = uint8(values[0]), uint8(values[1]), uint16(values[2])
+	if len(parts) == 2 {
+		n, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return version, fmt.Errorf("version %s => %v", data, err)
+		}
+		version.build = uint32(n)
+	}
+	return version, nil
+}
+
+func (v mcubootVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d+%d", v.major, v.minor, v.revision, v.build)
+}
+
+//parseMcubootOption parses the payload RANGE for --mcuboot
+func parseMcubootOption(value string, settings *mcubootSettings) (transform, error) {
+	r, err := parseAddressRange(value)
+	if err != nil {
+		return transform{}, err
+	}
+	return transform{
+		name: fmt.Sprintf("MCUboot header and trailer around %v", r),
+		apply: func(mem *gohex.Memory) error {
+			return addMcubootImage(mem, imageRange(mem, r), *settings)
+		},
+	}, nil
+}
+
+//mcubootHeader builds the image header, padded with zeros out to the header size
+func mcubootHeader(settings mcubootSettings, imageSize uint32) []byte {
+	header := make([]byte, settings.headerSize)
+	binary.LittleEndian.PutUint32(header[0:], mcubootImageMagic)
+	binary.LittleEndian.PutUint32(header[4:], settings.loadAddress)
+	binary.LittleEndian.PutUint16(header[8:], uint16(settings.headerSize))
+	binary.LittleEndian.PutUint16(header[10:], 0) //No protected TLVs
+	binary.LittleEndian.PutUint32(header[12:], imageSize)
+	binary.LittleEndian.PutUint32(header[16:], settings.flags)
+	header[20] = settings.version.major
+	header[21] = settings.version.minor
+	binary.LittleEndian.PutUint16(header[22:], settings.version.revision)
+	binary.LittleEndian.PutUint32(header[24:], settings.version.build)
+	return header
+}
+
+//mcubootTrailer builds the TLV area holding the SHA-256 of the header and payload
+func mcubootTrailer(header, payload []byte) []byte {
+	hash := sha256.New()
+	hash.Write(header)
+	hash.Write(payload)
+	digest := hash.Sum(nil)
+	trailer := make([]byte, 4+4+len(digest))
+	binary.LittleEndian.PutUint16(trailer[0:], mcubootTLVInfoMagic)
+	binary.LittleEndian.PutUint16(trailer[2:], uint16(len(trailer)))
+	trailer[4] = mcubootTLVSHA256
+	binary.LittleEndian.PutUint16(trailer[6:], uint16(len(digest)))
+	copy(trailer[8:], digest)
+	return trailer
+}
+
+//addMcubootImage places a header immediately before the payload range and the TLV trailer immediately after it
+//Gaps inside the payload are filled so the hashed bytes are the bytes that get programmed
+func addMcubootImage(mem *gohex.Memory, r addressRange, settings mcubootSettings) error {
+	if r.end <= r.start {
+		return fmt.Errorf("no payload to build an MCUboot image from")
+	}
+	if settings.headerSize < mcubootHeaderBytes || settings.headerSize > 0xFFFF {
+		return fmt.Errorf("header size %d should be between %d and 0xFFFF bytes", settings.headerSize, mcubootHeaderBytes)
+	}
+	if r.start < uint64(settings.headerSize) {
+		return fmt.Errorf("no room for a %d byte header before 0x%08X", settings.headerSize, r.start)
+	}
+	headerRange := addressRange{start: r.start - uint64(settings.headerSize), end: r.start}
+	if address, b, found := findData(mem, headerRange, 0x00, 0xFF); found {
+		return fmt.Errorf("header would overwrite data 0x%02X @ 0x%08X", b, address)
+	}
+	payload := readMemory(mem, r, settings.fill)
+	header := mcubootHeader(settings, uint32(len(payload)))
+	trailer := mcubootTrailer(header, payload)
+	if r.end+uint64(len(trailer)) > 1<<32 {
+		return fmt.Errorf("no room for the trailer after 0x%08X", r.end)
+	}
+	if address, b, found := findData(mem, addressRange{start: r.end, end: r.end + uint64(len(trailer))}, 0xFF); found {
+		return fmt.Errorf("trailer would overwrite data 0x%02X @ 0x%08X", b, address)
+	}
+	if err := fillGaps(mem, r, settings.fill); err != nil {
+		return err
+	}
+	mem.SetBinary(uint32(headerRange.start), header)
+	mem.SetBinary(uint32(r.end), trailer)
+	fmt.Printf("MCUboot image %v @ 0x%08X ; payload %d bytes ; header %d bytes ; trailer %d bytes\n", settings.version, headerRange.start, len(payload), len(header), len(trailer))
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestParseMcubootVersion(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		data    string
+		want    mcubootVersion
+		wantErr bool
+	}{
+		{"1", mcubootVersion{1, 0, 0, 0}, false},
+		{"1.2.3", mcubootVersion{1, 2, 3, 0}, false},
+		{"1.2.3+4", mcubootVersion{1, 2, 3, 4}, false},
+		{"255.255.65535+4294967295", mcubootVersion{255, 255, 65535, 4294967295}, false},
+		{"256.0.0", mcubootVersion{}, true},
+		{"1.2.3.4", mcubootVersion{}, true},
+		{"1.2+x", mcubootVersion{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			version, err := parseMcubootVersion(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && version != tt.want {
+				t.Errorf("got %v, want %v", version, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddMcubootImage(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x1200, []byte{1, 2, 3, 4})
+	mem.AddBinary(0x1208, []byte{5, 6, 7, 8})
+	settings := mcubootSettings{headerSize: 0x200, loadAddress: 0x20000000, flags: 0x20, version: mcubootVersion{1, 2, 3, 4}, fill: 0xFF}
+	err := addMcubootImage(mem, imageRange(mem, fullAddressRange), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := mem.GetDataSegments()
+	if len(segments) != 1 || segments[0].Address != 0x1000 {
+		t.Fatalf("Header, payload and trailer should be one segment, got %d", len(segments))
+	}
+	image := segments[0].Data
+	header := image[:0x200]
+	payload := image[0x200:0x20C]
+	trailer := image[0x20C:]
+	if !reflect.DeepEqual(payload, []byte{1, 2, 3, 4, 0xFF, 0xFF, 0xFF, 0xFF, 5, 6, 7, 8}) {
+		t.Errorf("Payload gaps should be filled, got %v", payload)
+	}
+	wantHeader := []byte{
+		0x3d, 0xb8, 0xf3, 0x96, //magic
+		0x00, 0x00, 0x00, 0x20, //load address
+		0x00, 0x02, //header size
+		0x00, 0x00, //protected TLV size
+		0x0C, 0x00, 0x00, 0x00, //image size
+		0x20, 0x00, 0x00, 0x00, //flags
+		1, 2, 3, 0, 4, 0, 0, 0, //version
+		0, 0, 0, 0, //padding
+	}
+	if !reflect.DeepEqual(header[:32], wantHeader) {
+		t.Errorf("got header %X, want %X", header[:32], wantHeader)
+	}
+	if !reflect.DeepEqual(header[32:], make([]byte, 0x200-32)) {
+		t.Error("Header should be zero padded")
+	}
+	if binary.LittleEndian.Uint16(trailer[0:]) != 0x6907 || binary.LittleEndian.Uint16(trailer[2:]) != 40 || len(trailer) != 40 {
+		t.Errorf("Bad TLV info header %X", trailer[:4])
+	}
+	if trailer[4] != 0x10 || binary.LittleEndian.Uint16(trailer[6:]) != 32 {
+		t.Errorf("Bad SHA256 TLV %X", trailer[4:8])
+	}
+	digest := sha256.Sum256(image[:0x20C])
+	if !reflect.DeepEqual(trailer[8:], digest[:]) {
+		t.Error("Trailer should hold the hash of the header and payload")
+	}
+}
+
+func TestAddMcubootImageErrors(t *testing.T) {
+	t.Parallel()
+	settings := mcubootSettings{headerSize: 0x200, fill: 0xFF}
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x100, []byte{1, 2, 3, 4})
+	if err := addMcubootImage(mem, imageRange(mem, fullAddressRange), settings); err == nil {
+		t.Error("Should raise error when there is no room for the header")
+	}
+	mem = gohex.NewMemory()
+	mem.AddBinary(0x1000, []byte{1})
+	mem.AddBinary(0x1200, []byte{1, 2, 3, 4})
+	if err := addMcubootImage(mem, addressRange{0x1200, 0x1204}, settings); err == nil {
+		t.Error("Should raise error when the header overwrites data")
+	}
+	settings.headerSize = 16
+	if err := addMcubootImage(mem, addressRange{0x1200, 0x1204}, settings); err == nil {
+		t.Error("Should raise error when the header is too small")
+	}
+}
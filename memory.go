@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//addressRange is a half open [start, end) span of the 32 bit address space
+//64 bit bounds are used so the range can cover the very last byte of memory
+type addressRange struct {
+	start uint64
+	end   uint64
+}
+
+var fullAddressRange = addressRange{start: 0, end: 1 << 32}
+
+//parseAddressRange parses either START-END (end exclusive) or START+LENGTH, "*" or "" selects everything
+func parseAddressRange(data string) (addressRange, error) {
+	if data == "" || data == "*" {
+		return fullAddressRange, nil
+	}
+	separator := strings.IndexAny(data, "-+")
+	if separator < 0 {
+		return addressRange{}, fmt.Errorf("range %s should be START-END or START+LENGTH", data)
+	}
+	start, err := parseNumberString(data[:separator])
+	if err != nil {
+		return addressRange{}, err
+	}
+	second, err := parseNumberString(data[separator+1:])
+	if err != nil {
+		return addressRange{}, err
+	}
+	r := addressRange{start: uint64(start), end: uint64(second)}
+	if data[separator] == '+' {
+		r.end = r.start + uint64(second)
+	}
+	if r.end > 1<<32 || r.end <= r.start {
+		return addressRange{}, fmt.Errorf("range %s is empty or outside of the address space", data)
+	}
+	return r, nil
+}
+
+func (r addressRange) String() string {
+	if r == fullAddressRange {
+		return "*"
+	}
+	return fmt.Sprintf("0x%08X-0x%08X", r.start, r.end)
+}
+
+//clip returns the part of the segment that falls inside the range
+//The returned data shares storage with the segment, so writes to it change the memory
+func (r addressRange) clip(seg gohex.DataSegment) (uint32, []byte, bool) {
+	start := uint64(seg.Address)
+	end := start + uint64(len(seg.Data))
+	if start < r.start {
+		start = r.start
+	}
+	if end > r.end {
+		end = r.end
+	}
+	if start >= end {
+		return 0, nil, false
+	}
+	offset := start - uint64(seg.Address)
+	return uint32(start), seg.Data[offset : offset+end-start], true
+}
+
+func alignDown(address, alignment uint64) uint64 {
+	return address - address%alignment
+}
+
+func alignUp(address, alignment uint64) uint64 {
+	return alignDown(address+alignment-1, alignment)
+}
+
+//repeatByte returns length copies of value
+func repeatByte(value byte, length int) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = value
+	}
+	return data
+}
+
+//memoryGaps lists the spans inside the range that hold no data
+func memoryGaps(mem *gohex.Memory, r addressRange) []addressRange {
+	return imageGaps(memoryImage{mem}, r)
+}
+
+//fillGaps writes the fill byte into every address of the range that doesn't already hold data
+func fillGaps(mem *gohex.Memory, r addressRange, fill byte) error {
+	for _, gap := range memoryGaps(mem, r) {
+		if err := mem.AddBinary(uint32(gap.start), repeatByte(fill, int(gap.end-gap.start))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//readMemory returns the bytes of the range, addresses without data read as the fill byte
+func readMemory(mem *gohex.Memory, r addressRange, fill byte) []byte {
+	data := repeatByte(fill, int(r.end-r.start))
+	for _, segment := range mem.GetDataSegments() {
+		address, segmentData, ok := r.clip(segment)
+		if ok {
+			copy(data[uint64(address)-r.start:], segmentData)
+		}
+	}
+	return data
+}
+
+//findData returns the first address in the range holding something other than the blank values
+func findData(mem *gohex.Memory, r addressRange, blank ...byte) (uint32, byte, bool) {
+	for _, segment := range mem.GetDataSegments() {
+		address, data, ok := r.clip(segment)
+		if !ok {
+			continue
+		}
+		for i, b := range data {
+			if bytes.IndexByte(blank, b) < 0 {
+				return address + uint32(i), b, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+//writeMemory sets the bytes at the address, like SetBinary but copying whole runs rather than a byte at a time
+func writeMemory(mem *gohex.Memory, address uint32, data []byte) error {
+	r := addressRange{start: uint64(address), end: uint64(address) + uint64(len(data))}
+	if r.end > 1<<32 {
+		return fmt.Errorf("%d bytes @ 0x%08X runs past the end of the address space", len(data), address)
+	}
+	for _, segment := range mem.GetDataSegments() {
+		existing, existingData, ok := r.clip(segment)
+		if ok {
+			copy(existingData, data[uint64(existing)-r.start:])
+		}
+	}
+	for _, gap := range memoryGaps(mem, r) {
+		//Copied so the memory never shares storage with the caller
+		gapData := append([]byte{}, data[gap.start-r.start:gap.end-r.start]...)
+		if err := mem.AddBinary(uint32(gap.start), gapData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//unionRanges sorts the ranges and joins any that overlap or touch
+func unionRanges(ranges []addressRange) []addressRange {
+	sorted := append([]addressRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+	merged := []addressRange{}
+	for _, r := range sorted {
+		if len(merged) > 0 && r.start <= merged[len(merged)-1].end {
+			if r.end > merged[len(merged)-1].end {
+				merged[len(merged)-1].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
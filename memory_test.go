@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestParseAddressRange(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		data    string
+		want    addressRange
+		wantErr bool
+	}{
+		{"", fullAddressRange, false},
+		{"*", fullAddressRange, false},
+		{"0x100-0x200", addressRange{0x100, 0x200}, false},
+		{"0x100+0x200", addressRange{0x100, 0x300}, false},
+		{"0xFFFFFF00+0x100", addressRange{0xFFFFFF00, 1 << 32}, false},
+		{"0xFFFFFF00+0x101", addressRange{}, true},
+		{"0x200-0x100", addressRange{}, true},
+		{"0x200", addressRange{}, true},
+		{"0x2x0-0x100", addressRange{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			r, err := parseAddressRange(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if r != tt.want {
+				t.Errorf("got %v, want %v", r, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressRangeClip(t *testing.T) {
+	t.Parallel()
+	seg := gohex.DataSegment{Address: 0x100, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	address, data, ok := addressRange{0x102, 0x104}.clip(seg)
+	if !ok || address != 0x102 || !reflect.DeepEqual(data, []byte{3, 4}) {
+		t.Errorf("got %v %v %v", address, data, ok)
+	}
+	data[0] = 0xAA
+	if seg.Data[2] != 0xAA {
+		t.Error("Clipped data should share storage with the segment")
+	}
+	_, _, ok = addressRange{0x108, 0x200}.clip(seg)
+	if ok {
+		t.Error("Range after the segment should not clip")
+	}
+	address, data, ok = fullAddressRange.clip(seg)
+	if !ok || address != 0x100 || len(data) != 8 {
+		t.Errorf("Full range should return whole segment")
+	}
+}
+
+func TestFillGaps(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x10, []byte{1, 2})
+	mem.AddBinary(0x14, []byte{3})
+	gaps := memoryGaps(mem, addressRange{0x0E, 0x18})
+	wantGaps := []addressRange{{0x0E, 0x10}, {0x12, 0x14}, {0x15, 0x18}}
+	if !reflect.DeepEqual(gaps, wantGaps) {
+		t.Fatalf("got %v, want %v", gaps, wantGaps)
+	}
+	err := fillGaps(mem, addressRange{0x0E, 0x18}, 0xFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := mem.GetDataSegments()
+	want := []byte{0xFF, 0xFF, 1, 2, 0xFF, 0xFF, 3, 0xFF, 0xFF, 0xFF}
+	if len(segments) != 1 || segments[0].Address != 0x0E || !reflect.DeepEqual(segments[0].Data, want) {
+		t.Errorf("got %v", segments)
+	}
+}
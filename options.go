@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//option is a single --name[=value] argument pulled out of the command line
+type option struct {
+	name  string
+	value string
+}
+
+//transform is a named operation applied to the merged memory before it is written out
+type transform struct {
+	name  string
+	apply func(mem *gohex.Memory) error
+}
+
+//outputOptions control how bin outputs are laid out
+type outputOptions struct {
+	padTo        uint64 //Length the bin output is padded out to, 0 leaves it at the end of the data
+	padToAddress bool   //padTo is an end address rather than a length
+	padByte      byte   //Value written into gaps and padding of bin outputs
+	alignSize    uint64 //Bin output length is rounded up to a multiple of this when set
+	layout       string //Hex file whose record layout hex outputs follow when set
+	canonical    int    //Record length of canonical hex output, 0 for the normal layout
+}
+
+//mergeOptions holds everything the default merge mode can be asked to do beyond merging
+type mergeOptions struct {
+	transforms   []transform
+	splitLanes   laneLayout //Write one output per lane when set
+	combineLanes laneLayout //Treat each input as one lane of the image when set
+	output       outputOptions
+	device       *device      //Memory map the final image is checked against when set
+	symbols      *symbolTable //Names for addresses in reports, nil when none are loaded
+	lenient      bool         //Skip bad records in hex inputs rather than failing
+	keepLayout   bool         //Hex outputs follow the layout of output.layout, or the first hex input when that is empty
+	dryRun       bool         //Show what would happen without writing files or prompting
+	report       string       //JSON file recording the inputs, overlaps, transforms and outputs of the run when set
+}
+
+//splitOptions separates --name[=value] options from the positional file arguments
+//A bare "--" stops option parsing so files starting with dashes can still be used
+func splitOptions(args []string) ([]option, []string) {
+	options := []option{}
+	positional := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if strings.HasPrefix(arg, "--") {
+			parts := strings.SplitN(arg[2:], "=", 2)
+			opt := option{name: parts[0]}
+			if len(parts) == 2 {
+				opt.value = parts[1]
+			}
+			options = append(options, opt)
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	return options, positional
+}
+
+//parseMergeOptions converts the options for the merge mode into settings, transforms keep their command line order
+func parseMergeOptions(options []option) (mergeOptions, error) {
+	settings := mergeOptions{}
+	stamps := &stampSettings{fill: 0xFF}
+	mcuboot := &mcubootSettings{headerSize: 0x200, fill: 0xFF}
+	digests := &digestSettings{fill: 0xFF}
+	signing := &signSettings{fill: 0xFF}
+	crypting := &cryptSettings{fill: 0xFF}
+	compressing := &compressSettings{fill: 0xFF}
+	trimming := &trimSettings{}
+	for _, opt := range options {
+		switch opt.name {
+		case "swap":
+			t, err := parseSwapOption(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --swap=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "split-lanes", "combine-lanes":
+			layout, err := parseLaneOption(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --%s=%s => %v", opt.name, opt.value, err)
+			}
+			if opt.name == "split-lanes" {
+				settings.splitLanes = layout
+			} else {
+				settings.combineLanes = layout
+			}
+		case "fill", "fill-gaps":
+			t, err := parseFillOption(opt.value, opt.name == "fill-gaps")
+			if err != nil {
+				return settings, fmt.Errorf("invalid --%s=%s => %v", opt.name, opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "pad-to":
+			value := strings.TrimPrefix(opt.value, "@")
+			n, err := parseNumberString(value)
+			if err != nil || n == 0 {
+				return settings, fmt.Errorf("invalid --pad-to=%s, should be SIZE or @ADDRESS", opt.value)
+			}
+			settings.output.padTo = uint64(n)
+			settings.output.padToAddress = value != opt.value
+		case "pad-byte":
+			value, err := parseByte(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --pad-byte=%s => %v", opt.value, err)
+			}
+			settings.output.padByte = value
+		case "align":
+			t, err := parseAlignOption(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --align=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "coalesce":
+			t, err := parseCoalesceOption(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --coalesce=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "split-fill":
+			t, err := parseSplitFillOption(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --split-fill=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "trim":
+			t, err := parseTrimOption(opt.value, trimming)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --trim=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "trim-drop-empty":
+			trimming.dropEmpty = true
+		case "align-size":
+			n, err := parseNumberString(opt.value)
+			if err != nil || n == 0 {
+				return settings, fmt.Errorf("invalid --align-size=%s, should be a non zero size", opt.value)
+			}
+			settings.output.alignSize = uint64(n)
+		case "stamp":
+			t, err := parseStampOption(opt.value, stamps)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --stamp=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "stamp-force":
+			stamps.force = true
+		case "stamp-fill":
+			value, err := parseByte(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --stamp-fill=%s => %v", opt.value, err)
+			}
+			stamps.fill = value
+		case "mcuboot":
+			t, err := parseMcubootOption(opt.value, mcuboot)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --mcuboot=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "mcuboot-header-size", "mcuboot-load-addr", "mcuboot-flags":
+			n, err := parseNumberString(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --%s=%s => %v", opt.name, opt.value, err)
+			}
+			switch opt.name {
+			case "mcuboot-header-size":
+				mcuboot.headerSize = n
+			case "mcuboot-load-addr":
+				mcuboot.loadAddress = n
+			case "mcuboot-flags":
+				mcuboot.flags = n
+			}
+		case "mcuboot-version":
+			version, err := parseMcubootVersion(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --mcuboot-version=%s => %v", opt.value, err)
+			}
+			mcuboot.version = version
+		case "digest":
+			t, err := parseDigestOption(opt.value, digests)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --digest=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "digest-fill":
+			value, err := parseByte(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --digest-fill=%s => %v", opt.value, err)
+			}
+			digests.fill = value
+		case "sign":
+			t, err := parseSignOption(opt.value, signing)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --sign=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "sign-fill":
+			value, err := parseByte(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --sign-fill=%s => %v", opt.value, err)
+			}
+			signing.fill = value
+		case "encrypt", "decrypt":
+			t, err := parseCryptOption(opt.value, opt.name == "decrypt", crypting)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --%s=%s => %v", opt.name, opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "encrypt-fill":
+			value, err := parseByte(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --encrypt-fill=%s => %v", opt.value, err)
+			}
+			crypting.fill = value
+		case "compress":
+			t, err := parseCompressOption(opt.value, compressing)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --compress=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "compress-fill":
+			value, err := parseByte(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --compress-fill=%s => %v", opt.value, err)
+			}
+			compressing.fill = value
+		case "device":
+			d, err := loadDevice(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --device=%s => %v", opt.value, err)
+			}
+			settings.device = &d
+		case "symbols":
+			table, err := loadSymbols(opt.value)
+			if err != nil {
+				return settings, err
+			}
+			if settings.symbols == nil {
+				settings.symbols = table
+			} else {
+				settings.symbols.merge(table)
+			}
+		case "patch":
+			t, err := parsePatchOption(opt.value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid --patch=%s => %v", opt.value, err)
+			}
+			settings.transforms = append(settings.transforms, t)
+		case "lenient":
+			settings.lenient = true
+		case "preserve-layout":
+			if opt.value != "" {
+				if isHex, _, _, err := parseFileTypeAndStart(opt.value); err != nil || !isHex {
+					return settings, fmt.Errorf("invalid --preserve-layout=%s => should be a .hex file", opt.value)
+				}
+			}
+			settings.keepLayout = true
+			settings.output.layout = opt.value
+		case "canonical":
+			settings.output.canonical = hexLineLength
+			if opt.value != "" {
+				n, err := parseNumberString(opt.value)
+				if err != nil || n == 0 || n > 128 || n&(n-1) != 0 {
+					return settings, fmt.Errorf("invalid --canonical=%s => record length should be a power of two up to 128", opt.value)
+				}
+				settings.output.canonical = int(n)
+			}
+		case "dry-run":
+			settings.dryRun = true
+			compressing.dryRun = true
+		case "report":
+			if strings.ToLower(filepath.Ext(opt.value)) != ".json" {
+				return settings, fmt.Errorf("invalid --report=%s => should be a .json file", opt.value)
+			}
+			settings.report = opt.value
+		default:
+			return settings, fmt.Errorf("unknown option --%s", opt.name)
+		}
+	}
+	if settings.keepLayout && settings.output.canonical > 0 {
+		return settings, fmt.Errorf("--preserve-layout and --canonical can't be used together")
+	}
+	if settings.dryRun && settings.report != "" {
+		return settings, fmt.Errorf("--dry-run writes nothing, so can't write --report")
+	}
+	return settings, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSplitOptions(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		args       []string
+		options    []option
+		positional []string
+	}{
+		{[]string{"1.hex", "2.bin"}, []option{}, []string{"1.hex", "2.bin"}},
+		{[]string{"--swap=16", "1.hex", "2.bin"}, []option{{"swap", "16"}}, []string{"1.hex", "2.bin"}},
+		{[]string{"1.hex", "--force", "2.bin"}, []option{{"force", ""}}, []string{"1.hex", "2.bin"}},
+		{[]string{"--a=b=c", "--", "--odd.hex", "2.bin"}, []option{{"a", "b=c"}}, []string{"--odd.hex", "2.bin"}},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%v", tt.args)
+		t.Run(testname, func(t *testing.T) {
+			options, positional := splitOptions(tt.args)
+			if !reflect.DeepEqual(options, tt.options) {
+				t.Errorf("got %v, want %v", options, tt.options)
+			}
+			if !reflect.DeepEqual(positional, tt.positional) {
+				t.Errorf("got %v, want %v", positional, tt.positional)
+			}
+		})
+	}
+}
+
+func TestParseMergeOptions(t *testing.T) {
+	t.Parallel()
+	settings, err := parseMergeOptions([]option{{"swap", "16"}, {"swap", "32:0x100-0x200"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settings.transforms) != 2 {
+		t.Fatalf("got %d transforms, want 2", len(settings.transforms))
+	}
+	if settings.transforms[1].name != "swap 32 bit words over 0x00000100-0x00000200" {
+		t.Errorf("transforms should keep command line order, got %v", settings.transforms[1].name)
+	}
+	_, err = parseMergeOptions([]option{{"nope", ""}})
+	if err == nil {
+		t.Error("Should raise error on unknown option")
+	}
+	_, err = parseMergeOptions([]option{{"swap", "24"}})
+	if err == nil {
+		t.Error("Should raise error on bad option value")
+	}
+	settings, err = parseMergeOptions([]option{{"canonical", ""}})
+	if err != nil || settings.output.canonical != hexLineLength {
+		t.Errorf("--canonical should default to %d byte records, got %d %v", hexLineLength, settings.output.canonical, err)
+	}
+	for _, bad := range [][]option{{{"canonical", "24"}}, {{"canonical", "256"}}, {{"canonical", ""}, {"preserve-layout", ""}}, {{"report", ""}}, {{"report", "report.txt"}}, {{"report", "r.json"}, {"dry-run", ""}}} {
+		if _, err := parseMergeOptions(bad); err == nil {
+			t.Errorf("Should raise error on %v", bad)
+		}
+	}
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+)
+
+//pageSize is how much of the address space each page of a pagedImage covers
+const pageSize = 64 * 1024
+
+//pageStore holds the data of pagedImages on disk rather than in memory
+//Data loaded from hex files, or changed after loading, lives a page at a time in a scratch file
+//Bin inputs aren't copied at all, their pages read straight from the file until something writes to them
+type pageStore struct {
+	scratch   *os.File
+	path      string //Set when the scratch file couldn't be removed while open, so it is removed on close
+	slots     int64  //Pages allocated in the scratch file
+	cache     []byte //One scratch page held in memory, as hex records arrive a few bytes at a time
+	cacheSlot int64
+	dirty     bool
+	inputs    []*os.File //Bin inputs that pages read from
+}
+
+func newPageStore() (*pageStore, error) {
+	scratch, err := os.CreateTemp("", "hexm_pages_*")
+	if err != nil {
+		return nil, err
+	}
+	s := &pageStore{scratch: scratch, cache: make([]byte, pageSize), cacheSlot: -1}
+	//Removed straight away where the OS allows it, so nothing is left behind when a run is ended early
+	if os.Remove(scratch.Name()) != nil {
+		s.path = scratch.Name()
+	}
+	return s, nil
+}
+
+func (s *pageStore) close() {
+	for _, input := range s.inputs {
+		input.Close()
+	}
+	s.scratch.Close()
+	if s.path != "" {
+		os.Remove(s.path)
+	}
+}
+
+//flush writes the cached page back to the scratch file
+func (s *pageStore) flush() error {
+	if !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	_, err := s.scratch.WriteAt(s.cache, s.cacheSlot*pageSize)
+	return err
+}
+
+//cacheSlotOf makes the scratch slot the cached page, the cache is left as is for a new slot as nothing reads an address before it is written
+func (s *pageStore) cacheSlotOf(slot int64, load bool) error {
+	if s.cacheSlot == slot {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.cacheSlot = slot
+	if !load {
+		return nil
+	}
+	if _, err := s.scratch.ReadAt(s.cache, slot*pageSize); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+//allocate gives the page a new scratch slot, returning the slot's cached bytes to fill in
+func (s *pageStore) allocate(page *imagePage) ([]byte, error) {
+	if err := s.cacheSlotOf(s.slots, false); err != nil {
+		return nil, err
+	}
+	page.file, page.offset = s.scratch, s.slots*pageSize
+	s.slots++
+	s.dirty = true
+	return s.cache, nil
+}
+
+//read fills data from the page starting at offset
+func (s *pageStore) read(page *imagePage, data []byte, offset uint32) error {
+	if page.file == s.scratch && page.offset/pageSize == s.cacheSlot {
+		copy(data, s.cache[offset:])
+		return nil
+	}
+	if _, err := page.file.ReadAt(data, page.offset+int64(offset)); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("%s is shorter than when it was loaded", page.file.Name())
+		}
+		return err
+	}
+	return nil
+}
+
+//own copies a page still reading from a bin input into the scratch file, so it can be written to
+func (s *pageStore) own(page *imagePage) error {
+	if page.file == s.scratch {
+		return nil
+	}
+	input, offset := *page, page.offset
+	cache, err := s.allocate(page)
+	if err != nil {
+		return err
+	}
+	//Only the addresses holding data are read, the rest of the page may be before the start or after the end of the file
+	for _, run := range input.runs() {
+		if _, err := input.file.ReadAt(cache[run.start:run.end], offset+int64(run.start)); err != nil {
+			return fmt.Errorf("reading %s => %v", input.file.Name(), err)
+		}
+	}
+	return nil
+}
+
+//write sets the bytes of a page already in the scratch file
+func (s *pageStore) write(page *imagePage, data []byte, offset uint32) error {
+	if err := s.cacheSlotOf(page.offset/pageSize, true); err != nil {
+		return err
+	}
+	copy(s.cache[offset:], data)
+	s.dirty = true
+	return nil
+}
+
+//imagePage is one pageSize span of a pagedImage
+type imagePage struct {
+	file    *os.File //The scratch file, or the bin input the page reads from until it is written to
+	offset  int64    //Where the page's first address is in the file, negative when a bin starts part way into the page
+	present []uint64 //Which addresses hold data, one bit each, nil when all of them do
+	count   int      //How many addresses hold data
+}
+
+func newImagePage() *imagePage {
+	return &imagePage{present: make([]uint64, pageSize/64)}
+}
+
+//mark records the addresses as holding data, returning how many didn't before
+func (p *imagePage) mark(from, to uint32) int {
+	if p.present == nil {
+		return 0
+	}
+	added := 0
+	for from < to {
+		word := from / 64
+		bitsInWord := 64 - from%64
+		if to-from < bitsInWord {
+			bitsInWord = to - from
+		}
+		mask := ^uint64(0) >> (64 - bitsInWord) << (from % 64)
+		added += bits.OnesCount64(mask &^ p.present[word])
+		p.present[word] |= mask
+		from += bitsInWord
+	}
+	p.count += added
+	if p.count == pageSize {
+		p.present = nil
+	}
+	return added
+}
+
+//holds returns if any of the addresses hold data
+func (p *imagePage) holds(from, to uint32) bool {
+	if p.present == nil {
+		return true
+	}
+	for from < to {
+		word := from / 64
+		bitsInWord := 64 - from%64
+		if to-from < bitsInWord {
+			bitsInWord = to - from
+		}
+		if p.present[word]&(^uint64(0)>>(64-bitsInWord)<<(from%64)) != 0 {
+			return true
+		}
+		from += bitsInWord
+	}
+	return false
+}
+
+//runs lists the offsets in the page holding data
+func (p *imagePage) runs() []addressRange {
+	if p.present == nil {
+		return []addressRange{{start: 0, end: pageSize}}
+	}
+	runs := []addressRange{}
+	i := uint64(0)
+	for i < pageSize {
+		//Bits shifted in from the top read as the opposite of what is being looked for, so a zero word moves on to the next one
+		word := p.present[i/64] >> (i % 64)
+		if word == 0 {
+			i = (i/64 + 1) * 64
+			continue
+		}
+		i += uint64(bits.TrailingZeros64(word))
+		start := i
+		for i < pageSize {
+			if word := ^p.present[i/64] >> (i % 64); word == 0 {
+				i = (i/64 + 1) * 64
+			} else {
+				i += uint64(bits.TrailingZeros64(word))
+				break
+			}
+		}
+		runs = append(runs, addressRange{start: start, end: i})
+	}
+	return runs
+}
+
+//pagedImage is a sparse image split into pages that are only read from disk when needed, so images of any size load in bounded memory
+type pagedImage struct {
+	store    *pageStore
+	pages    map[uint32]*imagePage //By address / pageSize
+	start    uint32
+	hasStart bool
+}
+
+func newPagedImage(store *pageStore) *pagedImage {
+	return &pagedImage{store: store, pages: map[uint32]*imagePage{}}
+}
+
+//keys returns the page numbers in address order
+func (p *pagedImage) keys() []uint32 {
+	keys := make([]uint32, 0, len(p.pages))
+	for key := range p.pages {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func (p *pagedImage) ranges() []addressRange {
+	ranges := []addressRange{}
+	for _, key := range p.keys() {
+		base := uint64(key) * pageSize
+		for _, run := range p.pages[key].runs() {
+			r := addressRange{start: base + run.start, end: base + run.end}
+			if n := len(ranges); n > 0 && ranges[n-1].end == r.start {
+				ranges[n-1].end = r.end
+				continue
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+//eachPage calls fn with every page the range covers, and the offsets of the range inside it
+func eachPage(address uint32, length int, fn func(key, from, to uint32, done int) error) error {
+	done := 0
+	for done < length {
+		key, from := address/pageSize, address%pageSize
+		to := uint32(pageSize)
+		if remaining := length - done; int(to-from) > remaining {
+			to = from + uint32(remaining)
+		}
+		if err := fn(key, from, to, done); err != nil {
+			return err
+		}
+		done += int(to - from)
+		address += to - from
+	}
+	return nil
+}
+
+func (p *pagedImage) readAt(data []byte, address uint32) error {
+	return eachPage(address, len(data), func(key, from, to uint32, done int) error {
+		page, ok := p.pages[key]
+		if !ok {
+			return fmt.Errorf("no data @ 0x%08X", key*pageSize+from)
+		}
+		return p.store.read(page, data[done:done+int(to-from)], from)
+	})
+}
+
+func (p *pagedImage) write(address uint32, data []byte) error {
+	if uint64(address)+uint64(len(data)) > 1<<32 {
+		return fmt.Errorf("%d bytes @ 0x%08X runs past the end of the address space", len(data), address)
+	}
+	return eachPage(address, len(data), func(key, from, to uint32, done int) error {
+		page, ok := p.pages[key]
+		if !ok {
+			page = newImagePage()
+			if to-from == pageSize {
+				page.present, page.count = nil, pageSize
+			}
+			if _, err := p.store.allocate(page); err != nil {
+				return err
+			}
+			p.pages[key] = page
+		} else if err := p.store.own(page); err != nil {
+			return err
+		}
+		if err := p.store.write(page, data[done:done+int(to-from)], from); err != nil {
+			return err
+		}
+		page.mark(from, to)
+		return nil
+	})
+}
+
+//add is write for loading, where data landing on data already loaded is an error as it is for gohex
+func (p *pagedImage) add(address uint32, data []byte) error {
+	if uint64(address)+uint64(len(data)) > 1<<32 {
+		return fmt.Errorf("%d bytes @ 0x%08X runs past the end of the address space", len(data), address)
+	}
+	overlap := eachPage(address, len(data), func(key, from, to uint32, done int) error {
+		if page, ok := p.pages[key]; ok && page.holds(from, to) {
+			return fmt.Errorf("data @ 0x%08X overlaps data already loaded", address)
+		}
+		return nil
+	})
+	if overlap != nil {
+		return overlap
+	}
+	return p.write(address, data)
+}
+
+func (p *pagedImage) startAddress() (uint32, bool) {
+	return p.start, p.hasStart
+}
+
+func (p *pagedImage) setStartAddress(address uint32) {
+	p.start, p.hasStart = address, true
+}
+
+//addFile adds length bytes of the file at the address without reading them, pages already holding data have it copied in instead
+func (p *pagedImage) addFile(file *os.File, address uint32, length int64) error {
+	if uint64(address)+uint64(length) > 1<<32 {
+		return fmt.Errorf("%s @ 0x%08X runs past the end of the address space", file.Name(), address)
+	}
+	var chunk []byte
+	return eachPage(address, int(length), func(key, from, to uint32, done int) error {
+		page, ok := p.pages[key]
+		if !ok {
+			page = &imagePage{file: file, offset: int64(done) - int64(from)}
+			if to-from < pageSize {
+				page.present = make([]uint64, pageSize/64)
+				page.mark(from, to)
+			} else {
+				page.count = pageSize
+			}
+			p.pages[key] = page
+			return nil
+		}
+		if page.holds(from, to) {
+			return fmt.Errorf("%s @ 0x%08X overlaps data already loaded", file.Name(), key*pageSize+from)
+		}
+		if chunk == nil {
+			chunk = make([]byte, pageSize)
+		}
+		data := chunk[:to-from]
+		if _, err := file.ReadAt(data, int64(done)); err != nil {
+			return err
+		}
+		return p.write(key*pageSize+from, data)
+	})
+}
+
+//addBin adds a bin input at the address, reading it from the file as needed
+func (p *pagedImage) addBin(path string, address uint32) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	p.store.inputs = append(p.store.inputs, file)
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return p.addFile(file, address, info.Size())
+}
+
+//addHex loads a hex file a record at a time into the scratch file
+func (p *pagedImage) addHex(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := newHexReader(file)
+	for {
+		address, data, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.add(address, data); err != nil {
+			return fmt.Errorf("line %d => %v", r.line, err)
+		}
+	}
+	if r.hasStart {
+		p.setStartAddress(r.start)
+	}
+	return nil
+}
+
+//addIndex adds the bins listed in a segment index, each read in place like any other bin
+func (p *pagedImage) addIndex(indexPath string) error {
+	index, err := readSegmentIndex(indexPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range index.Segments {
+		file, err := openIndexEntry(indexPath, entry)
+		if err != nil {
+			return err
+		}
+		p.store.inputs = append(p.store.inputs, file)
+		if err := p.addFile(file, uint32(entry.Address), int64(entry.Length)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//release copies every page still reading from the file into the scratch file, before the file is overwritten by an output
+func (p *pagedImage) release(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil //Nothing to overwrite
+	}
+	same := map[*os.File]bool{}
+	for _, key := range p.keys() {
+		page := p.pages[key]
+		if page.file == p.store.scratch {
+			continue
+		}
+		matches, checked := same[page.file]
+		if !checked {
+			if inputInfo, err := page.file.Stat(); err == nil {
+				matches = os.SameFile(info, inputInfo)
+			}
+			same[page.file] = matches
+		}
+		if matches {
+			if err := p.store.own(page); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//mergeFrom copies the ranges of another image in the same store, whole pages this image has nothing in are moved rather than copied
+//The other image loses the pages moved, so it shouldn't be used afterwards
+func (p *pagedImage) mergeFrom(other *pagedImage, merged []addressRange) error {
+	inMerged := func(address uint64) bool {
+		i := sort.Search(len(merged), func(i int) bool { return merged[i].end > address })
+		return i < len(merged) && merged[i].start <= address
+	}
+	var chunk []byte
+	for _, key := range other.keys() {
+		page := other.pages[key]
+		base := uint64(key) * pageSize
+		runs := page.runs()
+		if _, taken := p.pages[key]; !taken {
+			all := true
+			for _, run := range runs {
+				all = all && inMerged(base+run.start)
+			}
+			if all {
+				p.pages[key] = page
+				delete(other.pages, key)
+				continue
+			}
+		}
+		for _, run := range runs {
+			if !inMerged(base + run.start) {
+				continue
+			}
+			if chunk == nil {
+				chunk = make([]byte, pageSize)
+			}
+			data := chunk[:run.end-run.start]
+			if err := p.store.read(page, data, uint32(run.start)); err != nil {
+				return err
+			}
+			if err := p.write(uint32(base+run.start), data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//loadImage loads an input file into pages, hex records are copied into the scratch file while bins are read in place
+//The image is returned even on an error, holding whatever was loaded before it
+func loadImage(path string, store *pageStore) (*pagedImage, error) {
+	img := newPagedImage(store)
+	isHex, start, path, err := parseFileTypeAndStart(path)
+	if err != nil {
+		return img, err
+	}
+	switch {
+	case isSegmentIndex(path):
+		return img, img.addIndex(path)
+	case isHex:
+		return img, img.addHex(path)
+	}
+	return img, img.addBin(path, start)
+}
+
+//createOutput creates an output file, first copying out any of the image that is still read from it
+func createOutput(img image, path string) (*os.File, error) {
+	if paged, ok := img.(*pagedImage); ok {
+		if err := paged.release(path); err != nil {
+			return nil, err
+		}
+	}
+	return os.Create(path)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//tempBin writes data to a new temporary bin file, returning its path
+func tempBin(t testing.TB, data []byte) string {
+	file, err := os.CreateTemp("", "pages_*.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func patternData(length int, seed byte) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = byte(i*7) + seed
+	}
+	return data
+}
+
+func TestPagedImageWrite(t *testing.T) {
+	t.Parallel()
+	type write struct {
+		address uint32
+		data    []byte
+	}
+	var tests = []struct {
+		name   string
+		writes []write
+	}{
+		{"empty", nil},
+		{"short", []write{{0x100, []byte{1, 2, 3}}}},
+		{"whole page", []write{{0x10000, patternData(pageSize, 1)}}},
+		{"crosses pages", []write{{0x0800FFF0, patternData(pageSize+0x40, 2)}}},
+		{"touching", []write{{0x100, []byte{1, 2}}, {0x102, []byte{3}}, {0xFF, []byte{4}}}},
+		{"overwrite", []write{{0x100, patternData(0x100, 3)}, {0x180, []byte{0xAA, 0xBB}}, {0x1F0, patternData(0x20, 4)}}},
+		{"partial then full", []write{{0x20010, []byte{1}}, {0x20000, patternData(pageSize, 5)}}},
+		{"end of address space", []write{{0xFFFFFFFC, []byte{1, 2, 3, 4}}}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			store, err := newPageStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.close()
+			img := newPagedImage(store)
+			want := gohex.NewMemory()
+			for _, w := range tt.writes {
+				if err := img.write(w.address, w.data); err != nil {
+					t.Fatal(err)
+				}
+				writeMemory(want, w.address, w.data)
+			}
+			if !reflect.DeepEqual(img.ranges(), memoryImage{want}.ranges()) {
+				t.Errorf("got ranges %v, want %v", img.ranges(), memoryImage{want}.ranges())
+			}
+			got, err := toMemory(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.GetDataSegments(), want.GetDataSegments()) {
+				t.Errorf("got %v, want %v", got.GetDataSegments(), want.GetDataSegments())
+			}
+		})
+	}
+}
+
+func TestPagedImageAdd(t *testing.T) {
+	t.Parallel()
+	store, err := newPageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	img := newPagedImage(store)
+	if err := img.add(0x100, []byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := img.add(0x104, []byte{5}); err != nil {
+		t.Errorf("Touching data should load, got %v", err)
+	}
+	if err := img.add(0x103, []byte{6}); err == nil {
+		t.Error("Overlapping data should be an error")
+	}
+	if err := img.add(0xFFFFFFFF, []byte{1, 2}); err == nil {
+		t.Error("Data past the end of the address space should be an error")
+	}
+	if err := img.readAt(make([]byte, 1), 0x20000); err == nil {
+		t.Error("Reading an address without data should be an error")
+	}
+}
+
+func TestPagedImageBin(t *testing.T) {
+	t.Parallel()
+	data := patternData(3*pageSize+5, 9)
+	path := tempBin(t, data)
+	defer os.Remove(path)
+	store, err := newPageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	//Starting part way into a page, so the first and last pages are partial
+	img, err := loadImage(path+":0x1234", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.ranges(); !reflect.DeepEqual(got, []addressRange{{0x1234, 0x1234 + uint64(len(data))}}) {
+		t.Errorf("got ranges %v", got)
+	}
+	got := make([]byte, len(data))
+	if err := img.readAt(got, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Bin read back differently")
+	}
+	//Writing to the image must not change the bin it reads from
+	if err := img.write(0x1234+pageSize, []byte{0xAA, 0xBB}); err != nil {
+		t.Fatal(err)
+	}
+	if onDisk, _ := ioutil.ReadFile(path); !bytes.Equal(onDisk, data) {
+		t.Error("Writing to the image changed the bin input")
+	}
+	want := append([]byte{}, data...)
+	want[pageSize], want[pageSize+1] = 0xAA, 0xBB
+	//Writing the image back over the bin it was loaded from
+	if err := writeOutput(path+":0x1234", img, outputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if onDisk, _ := ioutil.ReadFile(path); !bytes.Equal(onDisk, want) {
+		t.Error("Overwriting the bin input wrote different data")
+	}
+}
+
+func TestLoadImageMatchesParseInputFile(t *testing.T) {
+	t.Parallel()
+	directory, err := os.MkdirTemp("", "pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	binPath := tempBin(t, patternData(2*pageSize+100, 3))
+	defer os.Remove(binPath)
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x0800FFF0, patternData(0x200, 1))
+	mem.AddBinary(0x08020000, patternData(pageSize, 2))
+	mem.AddBinary(0x90000000, []byte{1, 2, 3})
+	mem.SetStartAddress(0x08000101)
+	hexPath := directory + "/image.hex"
+	indexPath := directory + "/image.json"
+	for _, output := range []string{hexPath, indexPath} {
+		if err := writeOutput(output, memoryImage{mem}, outputOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := newPageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	for _, path := range []string{binPath + ":0x0800FF00", hexPath, indexPath} {
+		want, err := parseInputFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := loadImage(path, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := toMemory(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.GetDataSegments(), want.GetDataSegments()) {
+			t.Errorf("%s loads differently paged", path)
+		}
+		gotStart, gotOK := got.GetStartAddress()
+		wantStart, wantOK := want.GetStartAddress()
+		if gotStart != wantStart || gotOK != wantOK {
+			t.Errorf("%s start address 0x%08X %v, want 0x%08X %v", path, gotStart, gotOK, wantStart, wantOK)
+		}
+	}
+}
+
+func TestMergeSegmentsPaged(t *testing.T) {
+	t.Parallel()
+	first := patternData(2*pageSize, 1)
+	second := patternData(pageSize+0x100, 2)
+	firstPath, secondPath := tempBin(t, first), tempBin(t, second)
+	defer os.Remove(firstPath)
+	defer os.Remove(secondPath)
+	for _, overwrite := range []bool{true, false} {
+		store, err := newPageStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.close()
+		want := gohex.NewMemory()
+		merged := newPagedImage(store)
+		for _, path := range []string{firstPath + ":0x10000", secondPath + ":0x28000", firstPath + ":0x40000"} {
+			mem, err := parseInputFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := loadImage(path, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			confirm := func(segment addressRange, source string) bool { return overwrite }
+			if _, err := mergeSegments(memoryImage{want}, memoryImage{mem}, path, nil, confirm); err != nil {
+				t.Fatal(err)
+			}
+			overlaps, err := mergeSegments(merged, img, path, nil, confirm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (path == secondPath+":0x28000") != (len(overlaps) == 1) {
+				t.Errorf("%s got overlaps %v", path, overlaps)
+			}
+		}
+		got, err := toMemory(merged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.GetDataSegments(), want.GetDataSegments()) {
+			t.Errorf("Overwrite %v merged differently paged", overwrite)
+		}
+	}
+}
+
+//mergeLargeImage merges two bins and a hex overlapping them, writing the result as hex and bin
+func mergeLargeImage(t testing.TB, inputs []string, hexOutput, binOutput string) {
+	store, err := newPageStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	merged := newPagedImage(store)
+	for _, input := range inputs {
+		img, err := loadImage(input, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mergeSegments(merged, img, input, nil, func(segment addressRange, source string) bool { return true }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, output := range []string{hexOutput, binOutput} {
+		if err := writeOutput(output, merged, outputOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//largeImageInputs writes the inputs for mergeLargeImage, returning them and the image they merge to
+func largeImageInputs(t testing.TB, directory string, length int) ([]string, []byte) {
+	first, second := patternData(length, 1), patternData(length, 2)
+	patch := gohex.NewMemory()
+	patch.AddBinary(0x08000000+uint32(length)-0x800, patternData(0x1000, 3))
+	patchPath := directory + "/patch.hex"
+	if err := writeOutput(patchPath, memoryImage{patch}, outputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"first.bin": first, "second.bin": second} {
+		if err := ioutil.WriteFile(directory+"/"+name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inputs := []string{directory + "/first.bin:0x08000000", directory + fmt.Sprintf("/second.bin:0x%08X", 0x08000000+length), patchPath}
+	want := append(first, second...)
+	copy(want[length-0x800:], patternData(0x1000, 3))
+	return inputs, want
+}
+
+func TestMergeLargeImageBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes 128MB of temporary files")
+	}
+	//Not parallel, so the allocations counted are only this merge's
+	directory, err := os.MkdirTemp("", "pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	const length = 16 * 1024 * 1024
+	inputs, want := largeImageInputs(t, directory, length)
+	hexOutput, binOutput := directory+"/out.hex", directory+"/out.bin:0x08000000"
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	mergeLargeImage(t, inputs, hexOutput, binOutput)
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8*1024*1024 {
+		t.Errorf("Merging %d bytes allocated %d bytes", len(want), allocated)
+	}
+	if got, _ := ioutil.ReadFile(directory + "/out.bin"); !bytes.Equal(got, want) {
+		t.Error("Bin output differs from the merged inputs")
+	}
+	mem, err := parseInputFile(hexOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segments := mem.GetDataSegments(); len(segments) != 1 || segments[0].Address != 0x08000000 || !bytes.Equal(segments[0].Data, want) {
+		t.Error("Hex output differs from the merged inputs")
+	}
+}
+
+func BenchmarkMergeLargeImage(b *testing.B) {
+	directory, err := os.MkdirTemp("", "pages")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	const length = 16 * 1024 * 1024
+	inputs, want := largeImageInputs(b, directory, length)
+	b.SetBytes(int64(len(want)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mergeLargeImage(b, inputs, directory+"/out.hex", directory+"/out.bin:0x08000000")
+	}
+}
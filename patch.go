@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//patchNoData marks an address with no data in a patch, written as .. in patch files
+const patchNoData = -1
+
+//patchEntry replaces the old bytes at the address with the new ones
+//Each value is a byte or patchNoData, so a patch can add data to a gap or remove it
+type patchEntry struct {
+	address uint32
+	old     []int
+	new     []int
+}
+
+//parsePatchBytes parses space separated hex bytes, .. for no data
+func parsePatchBytes(data string) ([]int, error) {
+	values := []int{}
+	for _, field := range strings.Fields(data) {
+		if field == ".." {
+			values = append(values, patchNoData)
+			continue
+		}
+		n, err := parseNumberString("0x" + field)
+		if err != nil || len(field) != 2 {
+			return nil, fmt.Errorf("%s is not a hex byte", field)
+		}
+		values = append(values, int(n))
+	}
+	return values, nil
+}
+
+func formatPatchBytes(values []int) string {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		if value == patchNoData {
+			fields[i] = ".."
+		} else {
+			fields[i] = fmt.Sprintf("%02X", value)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+//readPatch parses lines of ADDRESS: OLD BYTES -> NEW BYTES, blank lines and # comments are skipped
+//Entries are checked against the original image, so ones that overlap are rejected rather than one quietly winning
+func readPatch(reader io.Reader) ([]patchEntry, error) {
+	entries := []patchEntry{}
+	patched := rangeSet{}
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = strings.TrimSpace(line[:index])
+		}
+		if line == "" {
+			continue
+		}
+		entry, err := parsePatchLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("patch line %d => %v", lineNumber, err)
+		}
+		if !patched.add(addressRange{start: uint64(entry.address), end: uint64(entry.address) + uint64(len(entry.old))}) {
+			return nil, fmt.Errorf("patch line %d => 0x%08X overlaps an earlier entry", lineNumber, entry.address)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func parsePatchLine(line string) (patchEntry, error) {
+	colon := strings.Index(line, ":")
+	arrow := strings.Index(line, "->")
+	if colon < 0 || arrow < colon {
+		return patchEntry{}, fmt.Errorf("should be ADDRESS: OLD BYTES -> NEW BYTES")
+	}
+	address, err := parseNumberString(strings.TrimSpace(line[:colon]))
+	if err != nil {
+		return patchEntry{}, err
+	}
+	old, err := parsePatchBytes(line[colon+1 : arrow])
+	if err != nil {
+		return patchEntry{}, err
+	}
+	replacement, err := parsePatchBytes(line[arrow+2:])
+	if err != nil {
+		return patchEntry{}, err
+	}
+	if len(old) != len(replacement) || len(old) == 0 {
+		return patchEntry{}, fmt.Errorf("old and new bytes should be the same non zero length")
+	}
+	if uint64(address)+uint64(len(old)) > 1<<32 {
+		return patchEntry{}, fmt.Errorf("patch @ 0x%08X runs past the end of the address space", address)
+	}
+	return patchEntry{address: address, old: old, new: replacement}, nil
+}
+
+//writePatch writes entries in the format readPatch reads
+func writePatch(writer io.Writer, entries []patchEntry) error {
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(writer, "0x%08X: %s -> %s\n", entry.address, formatPatchBytes(entry.old), formatPatchBytes(entry.new)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//memoryValues is a range of memory with a bit per address recording which addresses hold data
+type memoryValues struct {
+	data    []byte
+	present []uint64
+}
+
+//readMemoryValues returns the bytes of the range and which of them hold data
+func readMemoryValues(mem *gohex.Memory, r addressRange) memoryValues {
+	values := memoryValues{data: make([]byte, r.end-r.start), present: make([]uint64, (r.end-r.start+63)/64)}
+	for _, segment := range mem.GetDataSegments() {
+		address, data, ok := r.clip(segment)
+		if !ok {
+			continue
+		}
+		offset := uint64(address) - r.start
+		copy(values.data[offset:], data)
+		for i := offset; i < offset+uint64(len(data)); i++ {
+			values.present[i/64] |= 1 << (i % 64)
+		}
+	}
+	return values
+}
+
+//at returns the value at the offset into the range as a patch value, patchNoData where there is no data
+func (v memoryValues) at(i int) int {
+	if v.present[i/64]&(1<<(uint(i)%64)) == 0 {
+		return patchNoData
+	}
+	return int(v.data[i])
+}
+
+//applyPatch checks every entry matches the memory before changing anything, so a bad patch leaves the image untouched
+func applyPatch(mem *gohex.Memory, entries []patchEntry) error {
+	for _, entry := range entries {
+		r := addressRange{start: uint64(entry.address), end: uint64(entry.address) + uint64(len(entry.old))}
+		current := readMemoryValues(mem, r)
+		for i := range entry.old {
+			if current.at(i) != entry.old[i] {
+				found := make([]int, len(entry.old))
+				for j := range found {
+					found[j] = current.at(j)
+				}
+				return fmt.Errorf("patch @ 0x%08X expected %s but found %s", entry.address, formatPatchBytes(entry.old), formatPatchBytes(found))
+			}
+		}
+	}
+	for _, entry := range entries {
+		for i, value := range entry.new {
+			address := entry.address + uint32(i)
+			if value == patchNoData {
+				mem.RemoveBinary(address, 1)
+			} else {
+				mem.SetBinary(address, []byte{byte(value)})
+			}
+		}
+	}
+	return nil
+}
+
+//diffMemory builds the patch that turns old into updated, one entry per run of changed addresses
+//Runs are broken every 16 bytes to keep lines readable
+func diffMemory(old, updated *gohex.Memory) []patchEntry {
+	const maxRun = 16
+	entries := []patchEntry{}
+	//Only addresses holding data in either image can differ
+	spans := []addressRange{}
+	for _, mem := range []*gohex.Memory{old, updated} {
+		for _, segment := range mem.GetDataSegments() {
+			spans = append(spans, addressRange{start: uint64(segment.Address), end: uint64(segment.Address) + uint64(len(segment.Data))})
+		}
+	}
+	for _, span := range unionRanges(spans) {
+		oldValues := readMemoryValues(old, span)
+		updatedValues := readMemoryValues(updated, span)
+		var entry *patchEntry
+		for i := range oldValues.data {
+			oldValue, updatedValue := oldValues.at(i), updatedValues.at(i)
+			if oldValue == updatedValue || (entry != nil && len(entry.old) == maxRun) {
+				if entry != nil {
+					entries = append(entries, *entry)
+					entry = nil
+				}
+				if oldValue == updatedValue {
+					continue
+				}
+			}
+			if entry == nil {
+				entry = &patchEntry{address: uint32(span.start) + uint32(i)}
+			}
+			entry.old = append(entry.old, oldValue)
+			entry.new = append(entry.new, updatedValue)
+		}
+		if entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+//parsePatchOption loads a patch file for --patch
+func parsePatchOption(value string) (transform, error) {
+	file, err := os.Open(value)
+	if err != nil {
+		return transform{}, err
+	}
+	defer file.Close()
+	entries, err := readPatch(file)
+	if err != nil {
+		return transform{}, err
+	}
+	return transform{
+		name: fmt.Sprintf("patch %d changes from %s", len(entries), value),
+		apply: func(mem *gohex.Memory) error {
+			return applyPatch(mem, entries)
+		},
+	}, nil
+}
+
+//runGenPatch implements `hexm genpatch old new out.patch`, writing the patch that turns old into new
+func runGenPatch(args []string) error {
+	options, files := splitOptions(args)
+	if len(options) > 0 {
+		return fmt.Errorf("unknown option --%s", options[0].name)
+	}
+	if len(files) != 3 {
+		return fmt.Errorf("genpatch takes an old image, a new image and the patch file to write")
+	}
+	for _, input := range files[:2] {
+		if err := validateFile(input, true); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(files[2]); err == nil && !userConfirm(fmt.Sprintf("Overwrite %s?", files[2])) {
+		return fmt.Errorf("not overwriting %s", files[2])
+	}
+	old, err := parseInputFile(files[0])
+	if err != nil {
+		return err
+	}
+	updated, err := parseInputFile(files[1])
+	if err != nil {
+		return err
+	}
+	entries := diffMemory(old, updated)
+	file, err := os.Create(files[2])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintf(file, "# hexm patch from %s to %s\n", files[0], files[1]); err != nil {
+		return err
+	}
+	if err := writePatch(file, entries); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d changes to %s\n", len(entries), files[2])
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestReadPatch(t *testing.T) {
+	t.Parallel()
+	patch := `# A comment
+0x1000: 01 02 -> AA BB
+
+4100: .. .. -> 01 02 # trailing comment
+0x2000: ff -> ..
+`
+	entries, err := readPatch(strings.NewReader(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []patchEntry{
+		{0x1000, []int{1, 2}, []int{0xAA, 0xBB}},
+		{4100, []int{patchNoData, patchNoData}, []int{1, 2}},
+		{0x2000, []int{0xFF}, []int{patchNoData}},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got %v, want %v", entries, want)
+	}
+	for _, bad := range []string{"0x1000 01 -> 02", "0x1000: 01 02 -> 03", "0x1000: 1 -> 02", "0x1000: zz -> 02", "0x1000: -> ", "0xFFFFFFFF: 01 02 -> 03 04",
+		"0x1000: 01 02 -> 03 04\n0x1001: 02 -> 05", "0x1001: 02 -> 05\n0x1000: 01 02 03 -> 03 04 05"} {
+		if _, err := readPatch(strings.NewReader(bad)); err == nil {
+			t.Errorf("Should raise error on %q", bad)
+		}
+	}
+	if _, err := readPatch(strings.NewReader("0x1000: 01 -> 02\n0x1001: 02 -> 03")); err != nil {
+		t.Errorf("Adjacent entries should be accepted, got %v", err)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x1000, []byte{1, 2, 3, 4})
+	entries := []patchEntry{
+		{0x1001, []int{2, 3}, []int{0xAA, 0xBB}},
+		{0x1003, []int{4, patchNoData}, []int{patchNoData, 5}},
+	}
+	if err := applyPatch(mem, entries); err != nil {
+		t.Fatal(err)
+	}
+	want := []gohex.DataSegment{{Address: 0x1000, Data: []byte{1, 0xAA, 0xBB}}, {Address: 0x1004, Data: []byte{5}}}
+	if !reflect.DeepEqual(mem.GetDataSegments(), want) {
+		t.Errorf("got %v, want %v", mem.GetDataSegments(), want)
+	}
+	//The first entry matches, but nothing should change as the second doesn't
+	entries = []patchEntry{
+		{0x1000, []int{1}, []int{9}},
+		{0x1001, []int{2}, []int{9}},
+	}
+	if err := applyPatch(mem, entries); err == nil {
+		t.Fatal("Should raise error when old bytes don't match")
+	}
+	if !reflect.DeepEqual(mem.GetDataSegments(), want) {
+		t.Errorf("Failed patch should leave memory untouched, got %v", mem.GetDataSegments())
+	}
+}
+
+func TestDiffMemory(t *testing.T) {
+	t.Parallel()
+	old := gohex.NewMemory()
+	old.AddBinary(0x1000, []byte{1, 2, 3, 4, 5, 6})
+	old.AddBinary(0x2000, []byte{7})
+	updated := gohex.NewMemory()
+	updated.AddBinary(0x1000, []byte{1, 9, 9, 4, 5, 6, 8})
+	updated.AddBinary(0x3000, bytes.Repeat([]byte{0xAB}, 20))
+	entries := diffMemory(old, updated)
+	var buffer bytes.Buffer
+	if err := writePatch(&buffer, entries); err != nil {
+		t.Fatal(err)
+	}
+	want := `0x00001001: 02 03 -> 09 09
+0x00001006: .. -> 08
+0x00002000: 07 -> ..
+0x00003000: .. .. .. .. .. .. .. .. .. .. .. .. .. .. .. .. -> AB AB AB AB AB AB AB AB AB AB AB AB AB AB AB AB
+0x00003010: .. .. .. .. -> AB AB AB AB
+`
+	if buffer.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buffer.String(), want)
+	}
+	//Round trip, the written patch applied to old gives new
+	entries, err := readPatch(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPatch(old, entries); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(old.GetDataSegments(), updated.GetDataSegments()) {
+		t.Errorf("got %v, want %v", old.GetDataSegments(), updated.GetDataSegments())
+	}
+}
+
+func TestReadMemoryValues(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x1000, bytes.Repeat([]byte{0x5A}, 70))
+	mem.AddBinary(0x1050, []byte{0})
+	values := readMemoryValues(mem, addressRange{0x0FF0, 0x1060})
+	for i := range values.data {
+		want := patchNoData
+		if address := 0x0FF0 + i; (address >= 0x1000 && address < 0x1046) || address == 0x1050 {
+			want = int(mem.ToBinary(uint32(address), 1, 0xFF)[0])
+		}
+		if got := values.at(i); got != want {
+			t.Errorf("0x%04X got %d, want %d", 0x0FF0+i, got, want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//runReport is the --report record of what a merge read, did and wrote, for archiving alongside release images
+type runReport struct {
+	Inputs     []reportFile    `json:"inputs"`
+	Overlaps   []reportOverlap `json:"overlaps"`
+	Transforms []string        `json:"transforms"`
+	Segments   []reportRange   `json:"segments"` //The final image
+	Outputs    []reportFile    `json:"outputs"`
+}
+
+//reportFile is one file read or written
+type reportFile struct {
+	File     string        `json:"file"`
+	Format   string        `json:"format"`            //hex, bin, json or csv
+	Address  *jsonNumber   `json:"address,omitempty"` //Where a bin starts in the image
+	Size     int64         `json:"size"`
+	SHA256   string        `json:"sha256"`
+	Segments []reportRange `json:"segments,omitempty"`
+}
+
+type reportRange struct {
+	Address jsonNumber `json:"address"`
+	Length  uint64     `json:"length"`
+}
+
+//reportOverlap is a segment that landed on existing data, resolved by either overwriting it or skipping the segment
+type reportOverlap struct {
+	File       string        `json:"file"`
+	Segment    reportRange   `json:"segment"`
+	Existing   []reportRange `json:"existing"`
+	Owners     string        `json:"owners,omitempty"`
+	Resolution string        `json:"resolution"` //overwritten or skipped
+}
+
+//validateReportFile asks before overwriting an existing report, like every other output
+func validateReportFile(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		if !userConfirm(fmt.Sprintf("Overwrite %s?", path)) {
+			return fmt.Errorf("not overwriting %s", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("file %s raised IO error %v", path, err)
+	}
+	return nil
+}
+
+func newRunReport() *runReport {
+	return &runReport{Inputs: []reportFile{}, Overlaps: []reportOverlap{}, Transforms: []string{}, Segments: []reportRange{}, Outputs: []reportFile{}}
+}
+
+//rangeOf converts an address range for the report
+func rangeOf(r addressRange) reportRange {
+	return reportRange{Address: jsonNumber(r.start), Length: r.end - r.start}
+}
+
+//segmentRanges lists the segments of the image for the report
+func segmentRanges(img image) []reportRange {
+	ranges := []reportRange{}
+	for _, segment := range img.ranges() {
+		ranges = append(ranges, rangeOf(segment))
+	}
+	return ranges
+}
+
+//describeFile hashes a file on disk, naming the format from the path given on the command line
+func describeFile(userPath string) (reportFile, error) {
+	isHex, start, path, err := parseFileTypeAndStart(userPath)
+	if err != nil {
+		return reportFile{}, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return reportFile{}, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return reportFile{}, err
+	}
+	described := reportFile{File: path, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	switch {
+	case isSegmentIndex(path):
+		described.Format = strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	case isHex:
+		described.Format = "hex"
+	default:
+		described.Format = "bin"
+		address := jsonNumber(start)
+		described.Address = &address
+	}
+	return described, nil
+}
+
+//The add methods do nothing without a report, so callers don't need to check --report was given
+
+func (r *runReport) addInput(userPath string, img image) error {
+	if r == nil {
+		return nil
+	}
+	input, err := describeFile(userPath)
+	if err != nil {
+		return err
+	}
+	input.Segments = segmentRanges(img)
+	r.Inputs = append(r.Inputs, input)
+	return nil
+}
+
+func (r *runReport) addOverlaps(overlaps []mergeOverlap, symbols *symbolTable) {
+	if r == nil {
+		return
+	}
+	for _, overlap := range overlaps {
+		entry := reportOverlap{File: overlap.source, Segment: rangeOf(overlap.segment), Existing: []reportRange{}, Resolution: "skipped"}
+		if _, _, path, err := parseFileTypeAndStart(overlap.source); err == nil {
+			entry.File = path
+		}
+		owners := []string{}
+		for _, existing := range overlap.existing {
+			entry.Existing = append(entry.Existing, rangeOf(existing))
+			if owner := symbols.describeRange(existing); owner != "" {
+				owners = append(owners, owner)
+			}
+		}
+		entry.Owners = strings.Join(owners, ", ")
+		if overlap.merged {
+			entry.Resolution = "overwritten"
+		}
+		r.Overlaps = append(r.Overlaps, entry)
+	}
+}
+
+func (r *runReport) addTransform(name string) {
+	if r == nil {
+		return
+	}
+	r.Transforms = append(r.Transforms, name)
+}
+
+//addOutput records a written output, a segment index is recorded as each segment file then the index
+func (r *runReport) addOutput(userPath string, img image) error {
+	if r == nil {
+		return nil
+	}
+	_, _, path, err := parseFileTypeAndStart(userPath)
+	if err != nil {
+		return err
+	}
+	if isSegmentIndex(path) {
+		for _, segment := range img.ranges() {
+			output, err := describeFile(filepath.Join(filepath.Dir(path), segmentFileName(path, uint32(segment.start))) + fmt.Sprintf(":0x%08X", segment.start))
+			if err != nil {
+				return err
+			}
+			output.Segments = []reportRange{rangeOf(segment)}
+			r.Outputs = append(r.Outputs, output)
+		}
+	}
+	output, err := describeFile(userPath)
+	if err != nil {
+		return err
+	}
+	output.Segments = segmentRanges(img)
+	r.Outputs = append(r.Outputs, output)
+	return nil
+}
+
+//write saves the report as indented JSON
+func (r *runReport) write(path string, img image) error {
+	r.Segments = segmentRanges(img)
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
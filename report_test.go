@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestRunReport(t *testing.T) {
+	t.Parallel()
+	dir, err := os.MkdirTemp("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "in.bin")
+	if err := ioutil.WriteFile(input, []byte("abcd"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	base := gohex.NewMemory()
+	base.AddBinary(0x100, []byte{1, 2, 3, 4})
+	mem, err := parseInputFile(input + ":0x102")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newRunReport()
+	if err := report.addInput(input+":0x102", memoryImage{mem}); err != nil {
+		t.Fatal(err)
+	}
+	symbols := &symbolTable{symbols: []symbol{{"vectors", 0x100, 0x10}}}
+	symbols.finish()
+	overlaps, err := mergeSegments(memoryImage{base}, memoryImage{mem}, input+":0x102", symbols, func(segment addressRange, source string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	report.addOverlaps(overlaps, symbols)
+	report.addTransform("fill")
+	output := filepath.Join(dir, "out.json")
+	if err := writeOutput(output, memoryImage{base}, outputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := report.addOutput(output, memoryImage{base}); err != nil {
+		t.Fatal(err)
+	}
+	reportPath := filepath.Join(dir, "report.json")
+	if err := report.write(reportPath, memoryImage{base}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := runReport{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	address := jsonNumber(0x102)
+	wantInput := reportFile{File: input, Format: "bin", Address: &address, Size: 4,
+		SHA256:   "88d4266fd4e6338d13b845fcf289579d209c897823b9217da3e161936f031589",
+		Segments: []reportRange{{0x102, 4}}}
+	if len(got.Inputs) != 1 || !reflect.DeepEqual(got.Inputs[0], wantInput) {
+		t.Errorf("got inputs %+v, want %+v", got.Inputs, wantInput)
+	}
+	wantOverlap := reportOverlap{File: input, Segment: reportRange{0x102, 4}, Existing: []reportRange{{0x102, 2}}, Owners: "vectors", Resolution: "skipped"}
+	if len(got.Overlaps) != 1 || !reflect.DeepEqual(got.Overlaps[0], wantOverlap) {
+		t.Errorf("got overlaps %+v, want %+v", got.Overlaps, wantOverlap)
+	}
+	if !reflect.DeepEqual(got.Transforms, []string{"fill"}) || !reflect.DeepEqual(got.Segments, []reportRange{{0x100, 4}}) {
+		t.Errorf("got %v %v", got.Transforms, got.Segments)
+	}
+	if len(got.Outputs) != 2 || got.Outputs[0].File != filepath.Join(dir, "out.00000100.bin") || got.Outputs[0].Size != 4 || got.Outputs[1].File != output || got.Outputs[1].Format != "json" {
+		t.Errorf("got outputs %+v", got.Outputs)
+	}
+}
+
+func TestRunReportNil(t *testing.T) {
+	t.Parallel()
+	var report *runReport
+	if err := report.addInput("missing.bin:0", memoryImage{gohex.NewMemory()}); err != nil {
+		t.Error(err)
+	}
+	report.addOverlaps([]mergeOverlap{{source: "a.hex"}}, nil)
+	report.addTransform("fill")
+	if err := report.addOutput("missing.hex", memoryImage{gohex.NewMemory()}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateReportFile(t *testing.T) {
+	t.Parallel()
+	dir, err := os.MkdirTemp("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := validateReportFile(filepath.Join(dir, "new.json")); err != nil {
+		t.Errorf("A new report needs no checks, got %v", err)
+	}
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, nil, 0644)
+	if err := validateReportFile(filepath.Join(file, "report.json")); err == nil {
+		t.Error("Should raise error on a path that can't be written")
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 
 	"github.com/marcinbor85/gohex"
 )
@@ -14,6 +16,9 @@ func parseInputFile(path string) (*gohex.Memory, error) {
 	if err != nil {
 		return mem, err
 	}
+	if isSegmentIndex(path) {
+		return loadSegmentIndex(path)
+	}
 	if isHex {
 
 		file, err := os.Open(path)
@@ -39,64 +44,215 @@ func parseInputFile(path string) (*gohex.Memory, error) {
 	return mem, nil
 }
 
-func mergeSegments(base, addional *gohex.Memory, userPath string) {
-	existingSegments := base.GetDataSegments()
-	for x, segment := range addional.GetDataSegments() {
-		fmt.Printf("Section %d @ 0x%08X ; len %d\n", x+1, segment.Address, len(segment.Data))
-		//Check if this segment overlaps the existing segments
-		if len(existingSegments) > 0 {
-			for _, seg2 := range existingSegments {
-				if !segmentOverlaps(segment, seg2) || userConfirmOverlap(segment, userPath) {
-					//write this segment into it
-					base.SetBinary(segment.Address, segment.Data)
-				} else {
-					fmt.Printf("Did not merge the segment @ %08X", segment.Address)
-				}
+//mergeOverlap records a segment that landed on existing data, and whether it was written over it
+type mergeOverlap struct {
+	source   string
+	segment  addressRange
+	existing []addressRange //The addresses shared with data already merged
+	merged   bool
+}
+
+//mergeSegments copies the segments of addional into base, asking before overwriting data
+//confirm asks about each overlapping segment, nil prompts the user
+//Every overlap is returned with how it was resolved
+func mergeSegments(base writableImage, addional image, userPath string, symbols *symbolTable, confirm func(segment addressRange, source string) bool) ([]mergeOverlap, error) {
+	if confirm == nil {
+		confirm = userConfirmOverlap
+	}
+	overlaps := []mergeOverlap{}
+	existingSegments := base.ranges()
+	merged := []addressRange{}
+	for x, segment := range addional.ranges() {
+		fmt.Printf("Section %d @ 0x%08X ; len %d\n", x+1, segment.start, segment.end-segment.start)
+		//Check if this segment overlaps the existing segments, and show what it collides with
+		overlap := mergeOverlap{source: userPath, segment: segment}
+		i := sort.Search(len(existingSegments), func(i int) bool { return existingSegments[i].end > segment.start })
+		for _, seg2 := range existingSegments[i:] {
+			if seg2.start >= segment.end {
+				break
 			}
+			overlap.existing = append(overlap.existing, overlapRange(segment, seg2))
+			fmt.Println(describeOverlap(segment, seg2, symbols))
+		}
+		if len(overlap.existing) == 0 || confirm(segment, userPath) {
+			merged = append(merged, segment)
+			overlap.merged = true
 		} else {
-			base.SetBinary(segment.Address, segment.Data)
+			fmt.Printf("Did not merge the segment @ %08X\n", segment.start)
 		}
+		if len(overlap.existing) > 0 {
+			overlaps = append(overlaps, overlap)
+		}
+	}
+	//Segments of one input never overlap each other, so they can all be written once every question is answered
+	basePages, paged := base.(*pagedImage)
+	addionalPages, alsoPaged := addional.(*pagedImage)
+	if paged && alsoPaged && basePages.store == addionalPages.store {
+		return overlaps, basePages.mergeFrom(addionalPages, merged)
+	}
+	return overlaps, readChunks(addional, merged, base.write)
+}
+
+//overlapRange is the addresses two overlapping segments share
+func overlapRange(seg, seg2 addressRange) addressRange {
+	overlap := seg
+	if seg2.start > overlap.start {
+		overlap.start = seg2.start
+	}
+	if seg2.end < overlap.end {
+		overlap.end = seg2.end
+	}
+	return overlap
+}
+
+//describeOverlap reports the addresses two segments share, and what owns them when symbols are loaded
+func describeOverlap(seg, seg2 addressRange, symbols *symbolTable) string {
+	overlap := overlapRange(seg, seg2)
+	description := fmt.Sprintf("Overlaps existing data 0x%08X-0x%08X", overlap.start, overlap.end)
+	if owners := symbols.describeRange(overlap); owners != "" {
+		description += " ; " + owners
 	}
+	return description
 }
 
-func writeOutput(outputFile string, outputMemory *gohex.Memory) error {
+func writeOutput(outputFile string, img image, opts outputOptions) error {
 	outputHex, binaryStart, outputFile, err := parseFileTypeAndStart(outputFile)
 	if err != nil {
 		return err
 	}
-	file, err := os.Create(outputFile)
+	if err := checkOutputOptions(outputHex, outputFile, opts); err != nil {
+		return err
+	}
+	if isSegmentIndex(outputFile) {
+		return writeSegmentIndex(outputFile, img)
+	}
+	var template []byte
+	if outputHex && opts.layout != "" {
+		//Read before creating the output, which may be the template itself
+		if template, err = ioutil.ReadFile(opts.layout); err != nil {
+			return err
+		}
+	}
+	file, err := createOutput(img, outputFile)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
 	if outputHex {
-		err = outputMemory.DumpIntelHex(file, 32)
-		if err != nil {
-			return err
-		}
-	} else {
-		//We want to write a binary file starting at the specified location, and padding all gaps
-		existingSegments := outputMemory.GetDataSegments()
-		//Write out each section
-		for i, section := range existingSegments {
-			data := section.Data
-			start := section.Address - uint32(binaryStart)
-			if section.Address < binaryStart {
-				offset := int(binaryStart) - int(section.Address)
-				data = data[offset:]
-				start = 0 // As have no need to pad
-			}
-			err = checkFileStartPos(file, start)
+		if opts.layout != "" {
+			mem, err := toMemory(img)
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Writing %v bytes @ %08X for section %d\r\n", len(data), start, i+1)
-			_, err = file.WriteAt(data, int64(start))
-			if err != nil {
-				return err
+			return writeHexPreserving(file, mem, bytes.NewReader(template), opts.layout)
+		}
+		if opts.canonical > 0 {
+			return writeCanonicalHex(file, img, opts.canonical)
+		}
+		return writeHex(file, img)
+	}
+	return writeBinary(file, img, binaryStart, opts)
+}
+
+//checkOutputOptions rejects the bin padding options for outputs that aren't a single bin, rather than ignoring them
+func checkOutputOptions(outputHex bool, outputFile string, opts outputOptions) error {
+	if isSegmentIndex(outputFile) {
+		return checkIndexOptions(opts)
+	}
+	if outputHex && (opts.padTo != 0 || opts.padByte != 0 || opts.alignSize != 0) {
+		return fmt.Errorf("--pad-to, --pad-byte and --align-size only apply to bin outputs, hex outputs only hold the data")
+	}
+	return nil
+}
+
+//writeBinary writes a binary file starting at the specified location, padding all gaps
+func writeBinary(file *os.File, img image, binaryStart uint32, opts outputOptions) error {
+	fileRange := addressRange{start: uint64(binaryStart), end: 1 << 32}
+	sections := []addressRange{}
+	//Write out each section
+	for i, section := range img.ranges() {
+		if section.end <= fileRange.start {
+			continue
+		}
+		if section.start < fileRange.start {
+			section.start = fileRange.start // As have no need to pad
+		}
+		start := uint32(section.start - fileRange.start)
+		err := checkFileStartPos(file, start)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Writing %v bytes @ %08X for section %d\r\n", section.end-section.start, start, i+1)
+		sections = append(sections, section)
+	}
+	err := readChunks(img, sections, func(address uint32, data []byte) error {
+		_, err := file.WriteAt(data, int64(uint64(address)-fileRange.start))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	padded, length, err := binaryLength(img, binaryStart, opts)
+	if err != nil {
+		return err
+	}
+	if length != padded {
+		fmt.Printf("Padding output by %d bytes to a multiple of %d\r\n", length-padded, opts.alignSize)
+	}
+	if opts.padTo == 0 && opts.alignSize == 0 && opts.padByte == 0 {
+		//Gaps are left as zeros by the file system
+		return nil
+	}
+	fileRange.end = fileRange.start + length
+	for _, gap := range imageGaps(img, fileRange) {
+		if err := writePadding(file, gap.start-uint64(binaryStart), gap.end-gap.start, opts.padByte); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//binaryLength returns the length of a bin output starting at binaryStart after --pad-to, then after --align-size
+func binaryLength(img image, binaryStart uint32, opts outputOptions) (uint64, uint64, error) {
+	length := uint64(0)
+	for _, segment := range img.ranges() {
+		if segment.end > uint64(binaryStart) {
+			length = segment.end - uint64(binaryStart)
+		}
+	}
+	if opts.padTo > 0 {
+		target := opts.padTo
+		if opts.padToAddress {
+			if target < uint64(binaryStart) {
+				return 0, 0, fmt.Errorf("pad to address 0x%08X is before the start of the file 0x%08X", target, binaryStart)
 			}
+			target -= uint64(binaryStart)
+		}
+		if length > target {
+			return 0, 0, fmt.Errorf("output is %d bytes which is more than the %d it should be padded to", length, target)
+		}
+		length = target
+	}
+	if opts.alignSize > 0 {
+		return length, alignUp(length, opts.alignSize), nil
+	}
+	return length, length, nil
+}
+
+//writePadding writes length copies of value at offset, in chunks so large gaps don't need one huge buffer
+func writePadding(file *os.File, offset, length uint64, value byte) error {
+	chunk := repeatByte(value, 64*1024)
+	for length > 0 {
+		size := uint64(len(chunk))
+		if size > length {
+			size = length
 		}
+		if _, err := file.WriteAt(chunk[:size], int64(offset)); err != nil {
+			return err
+		}
+		offset += size
+		length -= size
 	}
 	return nil
 }
@@ -112,18 +268,10 @@ func checkFileStartPos(file *os.File, start uint32) error {
 		padMBytes := start - currentFileSize
 		padMBytes /= 1024 * 1024
 		if padMBytes > 128 {
-			if !userConfirm(fmt.Sprintf("Output file will contain at least %d Mbytes of padding, are you sure?", padMBytes)) {
+			if !userConfirm(fmt.Sprintf("Output file will contain at least %d Mbytes of padding, are you sure? (a .json or .csv output writes each segment separately)", padMBytes)) {
 				return fmt.Errorf("user aborted write due to padding of %v Mbytes", padMBytes)
 			}
 		}
 	}
 	return nil
 }
-
-func segmentOverlaps(seg gohex.DataSegment, seg2 gohex.DataSegment) bool {
-	if ((seg2.Address >= seg.Address) && (seg2.Address < seg.Address+uint32(len(seg.Data)))) ||
-		((seg2.Address < seg.Address) && (seg2.Address+uint32(len(seg2.Data))) > seg.Address) {
-		return true
-	}
-	return false
-}
@@ -127,15 +127,15 @@ func TestMergeSegments(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	mergeSegments(mem3, mem1, "")
-	mergeSegments(mem3, mem2, "")
+	mergeSegments(memoryImage{mem3}, memoryImage{mem1}, "", nil, nil)
+	mergeSegments(memoryImage{mem3}, memoryImage{mem2}, "", nil, nil)
 	if !reflect.DeepEqual(mem3.GetDataSegments()[0].Data, data) {
 		t.Fatal("Merge should handle simple case")
 	}
 	//test order is ignored
 	mem3 = gohex.NewMemory()
-	mergeSegments(mem3, mem2, "")
-	mergeSegments(mem3, mem1, "")
+	mergeSegments(memoryImage{mem3}, memoryImage{mem2}, "", nil, nil)
+	mergeSegments(memoryImage{mem3}, memoryImage{mem1}, "", nil, nil)
 	if !reflect.DeepEqual(mem3.GetDataSegments()[0].Data, data) {
 		t.Fatal("Merge should handle simple case")
 	}
@@ -179,13 +179,13 @@ func TestMergeSegmentsOverlap(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	mergeSegments(mem3, mem1, "")
-	mergeSegments(mem3, mem2, "")
+	mergeSegments(memoryImage{mem3}, memoryImage{mem1}, "", nil, nil)
+	mergeSegments(memoryImage{mem3}, memoryImage{mem2}, "", nil, nil)
 	if !reflect.DeepEqual(mem3.GetDataSegments()[0].Data, data) {
 		t.Fatal("Merge should handle simple case")
 	}
 	//run again and should overwrite
-	mergeSegments(mem3, mem1, "")
+	mergeSegments(memoryImage{mem3}, memoryImage{mem1}, "", nil, nil)
 	if !reflect.DeepEqual(mem3.GetDataSegments()[0].Data, data) {
 		t.Fatal("Merge should handle simple case")
 	}
@@ -206,7 +206,7 @@ func TestMergeSegmentsOverlap(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	mergeSegments(mem3, mem1, "")
+	mergeSegments(memoryImage{mem3}, memoryImage{mem1}, "", nil, nil)
 	if !reflect.DeepEqual(mem3.GetDataSegments()[0].Data, data) {
 		t.Fatal("Merge should reject overwrite i user opts out")
 	}
@@ -233,7 +233,7 @@ func TestWriteOutputBlobToBin(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = writeOutput(tmpfile.Name(), mem) // will have written out a hex file now
+	err = writeOutput(tmpfile.Name(), memoryImage{mem}, outputOptions{}) // will have written out a hex file now
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -249,11 +249,11 @@ func TestWriteOutputBlobToBin(t *testing.T) {
 func TestWriteOutputFails(t *testing.T) {
 	t.Parallel()
 	mem := gohex.NewMemory()
-	err := writeOutput("badname.bad", mem) // will have written out a hex file now
+	err := writeOutput("badname.bad", memoryImage{mem}, outputOptions{}) // will have written out a hex file now
 	if err == nil {
 		t.Fatal("Should raise error on bad name format")
 	}
-	err = writeOutput("/badfolder/test.hex", mem) // will have written out a hex file now
+	err = writeOutput("/badfolder/test.hex", memoryImage{mem}, outputOptions{}) // will have written out a hex file now
 	if err == nil {
 		t.Fatal("Should raise error on uncreatable file")
 	}
@@ -282,7 +282,7 @@ func TestWriteOutputBlobToHex(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = writeOutput(tmpfile.Name(), mem) // will have written out a hex file now
+	err = writeOutput(tmpfile.Name(), memoryImage{mem}, outputOptions{}) // will have written out a hex file now
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -325,7 +325,7 @@ func TestWriteOutputBlobToBinOffset(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = writeOutput(tmpfile.Name()+fmt.Sprintf(":%d", offset), mem) // will have written out a hex file now
+	err = writeOutput(tmpfile.Name()+fmt.Sprintf(":%d", offset), memoryImage{mem}, outputOptions{}) // will have written out a hex file now
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -359,7 +359,7 @@ func TestWriteOutputBlobToBinOffsetPadding(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = writeOutput(tmpfile.Name(), mem) // will have written out a hex file now
+	err = writeOutput(tmpfile.Name(), memoryImage{mem}, outputOptions{}) // will have written out a hex file now
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -393,7 +393,7 @@ func TestWriteOutputBlobToBinOffsetTruncateState(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = writeOutput(tmpfile.Name()+fmt.Sprintf(":%d", offset), mem) // will have written out a hex file now
+	err = writeOutput(tmpfile.Name()+fmt.Sprintf(":%d", offset), memoryImage{mem}, outputOptions{}) // will have written out a hex file now
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -405,3 +405,53 @@ func TestWriteOutputBlobToBinOffsetTruncateState(t *testing.T) {
 		t.Fatal("Rebase should truncate off leading data")
 	}
 }
+
+func TestWriteOutputPadTo(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name    string
+		suffix  string
+		opts    outputOptions
+		want    []byte
+		wantErr bool
+	}{
+		{"gaps", "", outputOptions{padByte: 0xFF}, []byte{0xFF, 1, 2, 0xFF, 3}, false},
+		{"size", "", outputOptions{padTo: 8, padByte: 0xFF}, []byte{0xFF, 1, 2, 0xFF, 3, 0xFF, 0xFF, 0xFF}, false},
+		{"zeros", "", outputOptions{padTo: 6}, []byte{0, 1, 2, 0, 3, 0}, false},
+		{"address", ":1", outputOptions{padTo: 8, padToAddress: true, padByte: 0xEE}, []byte{1, 2, 0xEE, 3, 0xEE, 0xEE, 0xEE}, false},
+		{"align", "", outputOptions{alignSize: 4, padByte: 0xFF}, []byte{0xFF, 1, 2, 0xFF, 3, 0xFF, 0xFF, 0xFF}, false},
+		{"aligned", "", outputOptions{alignSize: 5}, []byte{0, 1, 2, 0, 3}, false},
+		{"too small", "", outputOptions{padTo: 4}, nil, true},
+		{"before start", ":2", outputOptions{padTo: 1, padToAddress: true}, nil, true},
+		{"hex pad", ".hex", outputOptions{padTo: 8}, nil, true},
+		{"hex pad byte", ".hex", outputOptions{padByte: 0xFF}, nil, true},
+		{"hex align", ".hex", outputOptions{alignSize: 4}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpfile, err := os.CreateTemp("", "*_padto.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tmpfile.Close()
+			defer os.Remove(tmpfile.Name())
+			mem := gohex.NewMemory()
+			mem.AddBinary(1, []byte{1, 2})
+			mem.AddBinary(4, []byte{3})
+			err = writeOutput(tmpfile.Name()+tt.suffix, memoryImage{mem}, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			dataread, err := ioutil.ReadFile(tmpfile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dataread, tt.want) {
+				t.Errorf("got %v, want %v", dataread, tt.want)
+			}
+		})
+	}
+}
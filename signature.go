@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//signSettings are shared by every --sign, so --sign-fill applies wherever it is given
+type signSettings struct {
+	fill byte
+}
+
+//loadPEM returns the first PEM block in the file
+func loadPEM(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+//loadPrivateKey reads an Ed25519, ECDSA P-256 or RSA private key from a PEM file
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	block, err := loadPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key %s => %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type in %s", path)
+	}
+	if err := checkKeyType(signer.Public()); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+//loadPublicKey reads a public key from a PEM file, a private key file can be used as well
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := loadPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(block.Type, "PRIVATE KEY") {
+		signer, err := loadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return signer.Public(), nil
+	}
+	var key crypto.PublicKey
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key %s => %v", path, err)
+	}
+	return key, checkKeyType(key)
+}
+
+//checkKeyType limits keys to the types a bootloader is likely to verify
+func checkKeyType(key crypto.PublicKey) error {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return nil
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return fmt.Errorf("RSA key is %d bits, should be at least 2048", k.N.BitLen())
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported key type %T, should be Ed25519, ECDSA P-256 or RSA", key)
+}
+
+//signatureLength is how many bytes a signature made by the key takes up in the image
+func signatureLength(key crypto.PublicKey) int {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return k.Size()
+	default:
+		//Ed25519 and ECDSA P-256 as raw r|s are both 64 bytes
+		return 64
+	}
+}
+
+//signDigest signs a SHA-256 digest
+//ECDSA signatures are stored as fixed length r|s rather than ASN.1 so they fit in a fixed slot, RSA uses PKCS #1 v1.5
+func signDigest(key crypto.Signer, digest []byte) ([]byte, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, digest), nil
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest)
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+//verifyDigest checks a signature made by signDigest
+func verifyDigest(key crypto.PublicKey, digest, signature []byte) error {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, digest, signature) {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if ecdsa.Verify(k, digest, r, s) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, signature) == nil {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return fmt.Errorf("signature does not match")
+}
+
+//parseSignOption parses KEYFILE:RANGE[@ADDRESS], without an address the signature is appended after the range
+func parseSignOption(value string, settings *signSettings) (transform, error) {
+	parts := strings.SplitN(value, ":", 2)
+	key, err := loadPrivateKey(parts[0])
+	if err != nil {
+		return transform{}, err
+	}
+	rangeText := ""
+	if len(parts) == 2 {
+		rangeText = parts[1]
+	}
+	appended := true
+	var address uint32
+	if index := strings.Index(rangeText, "@"); index >= 0 {
+		address, err = parseNumberString(rangeText[index+1:])
+		if err != nil {
+			return transform{}, err
+		}
+		appended = false
+		rangeText = rangeText[:index]
+	}
+	r, err := parseAddressRange(rangeText)
+	if err != nil {
+		return transform{}, err
+	}
+	return transform{
+		name: fmt.Sprintf("sign %v with %s", r, parts[0]),
+		apply: func(mem *gohex.Memory) error {
+			signed := imageRange(mem, r)
+			if appended {
+				if signed.end >= 1<<32 {
+					return fmt.Errorf("no room after %v for the signature", signed)
+				}
+				address = uint32(signed.end)
+			}
+			return signMemory(mem, signed, address, key, settings.fill)
+		},
+	}, nil
+}
+
+//signMemory signs the SHA-256 of the range and writes the signature at the address
+func signMemory(mem *gohex.Memory, signed addressRange, address uint32, key crypto.Signer, fill byte) error {
+	digest, err := digestMemory(mem, signed, fill, "sha256")
+	if err != nil {
+		return err
+	}
+	signature, err := signDigest(key, digest)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signed %v, %d byte signature @ 0x%08X\n", signed, len(signature), address)
+	//The signature has the same placement rules as a stored digest
+	return storeDigest(mem, signed, address, signature, fill)
+}
+
+//verifyMemory checks the signature stored at the address against the SHA-256 of the range
+func verifyMemory(mem *gohex.Memory, signed addressRange, address uint32, key crypto.PublicKey, fill byte) error {
+	signatureRange := addressRange{start: uint64(address), end: uint64(address) + uint64(signatureLength(key))}
+	if _, _, found := findData(mem, signatureRange); !found {
+		return fmt.Errorf("no signature @ 0x%08X", address)
+	}
+	signature := readMemory(mem, signatureRange, fill)
+	digest, err := digestMemory(mem, signed, fill, "sha256")
+	if err != nil {
+		return err
+	}
+	return verifyDigest(key, digest, signature)
+}
+
+//runVerify implements `hexm verify file[:range] --key=public.pem [--sig=address]`
+//Without --sig the signature is expected straight after the range, and a whole image range excludes it
+func runVerify(args []string) error {
+	options, files := splitOptions(args)
+	keyPath := ""
+	fill := byte(0xFF)
+	var address uint32
+	appended := true
+	for _, opt := range options {
+		switch opt.name {
+		case "key":
+			keyPath = opt.value
+		case "sig":
+			n, err := parseNumberString(opt.value)
+			if err != nil {
+				return fmt.Errorf("invalid --sig=%s => %v", opt.value, err)
+			}
+			address = n
+			appended = false
+		case "fill":
+			value, err := parseByte(opt.value)
+			if err != nil {
+				return fmt.Errorf("invalid --fill=%s => %v", opt.value, err)
+			}
+			fill = value
+		default:
+			return fmt.Errorf("unknown option --%s", opt.name)
+		}
+	}
+	if keyPath == "" {
+		return fmt.Errorf("no --key given to verify with")
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("verify takes exactly one file")
+	}
+	key, err := loadPublicKey(keyPath)
+	if err != nil {
+		return err
+	}
+	path, r, err := splitPathRange(files[0])
+	if err != nil {
+		return err
+	}
+	if err := validateFile(path, true); err != nil {
+		return err
+	}
+	mem, err := parseInputFile(path)
+	if err != nil {
+		return err
+	}
+	signed := imageRange(mem, r)
+	if appended {
+		if r == fullAddressRange {
+			if signed.end-signed.start < uint64(signatureLength(key)) {
+				return fmt.Errorf("%s is too small to hold a %d byte signature", path, signatureLength(key))
+			}
+			signed.end -= uint64(signatureLength(key))
+		}
+		if signed.end >= 1<<32 {
+			return fmt.Errorf("no room after %v for a signature", signed)
+		}
+		address = uint32(signed.end)
+	}
+	if err := verifyMemory(mem, signed, address, key, fill); err != nil {
+		return fmt.Errorf("%s %v failed verification => %v", path, signed, err)
+	}
+	fmt.Printf("%s %v signature @ 0x%08X verified OK\n", path, signed, address)
+	return nil
+}
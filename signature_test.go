@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//writeTestKeys saves the key as PKCS #8 private and PKIX public PEM files
+func writeTestKeys(t *testing.T, key crypto.Signer) (privatePath, publicPath string) {
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicBytes, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []pem.Block{{Type: "PRIVATE KEY", Bytes: privateBytes}, {Type: "PUBLIC KEY", Bytes: publicBytes}} {
+		tmpfile, err := os.CreateTemp("", "*_key.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pem.Encode(tmpfile, &block)
+		tmpfile.Close()
+		if privatePath == "" {
+			privatePath = tmpfile.Name()
+		} else {
+			publicPath = tmpfile.Name()
+		}
+	}
+	return privatePath, publicPath
+}
+
+func TestSignAndVerify(t *testing.T) {
+	t.Parallel()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name   string
+		key    crypto.Signer
+		length int
+	}{
+		{"ed25519", edKey, 64},
+		{"ecdsa", ecKey, 64},
+		{"rsa", rsaKey, 256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			privatePath, publicPath := writeTestKeys(t, tt.key)
+			defer os.Remove(privatePath)
+			defer os.Remove(publicPath)
+			sign, err := parseSignOption(privatePath+":*", &signSettings{fill: 0xFF})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mem := gohex.NewMemory()
+			mem.AddBinary(0x1000, []byte{1, 2, 3, 4})
+			mem.AddBinary(0x1008, []byte{5, 6, 7, 8})
+			if err := sign.apply(mem); err != nil {
+				t.Fatal(err)
+			}
+			segments := mem.GetDataSegments()
+			if len(segments[1].Data) != 4+tt.length {
+				t.Fatalf("Signature should be appended, got %d bytes", len(segments[1].Data))
+			}
+			key, err := loadPublicKey(publicPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed := addressRange{0x1000, 0x100C}
+			if err := verifyMemory(mem, signed, 0x100C, key, 0xFF); err != nil {
+				t.Error(err)
+			}
+			//Private keys can be used to verify as well
+			key, err = loadPublicKey(privatePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifyMemory(mem, signed, 0x100C, key, 0xFF); err != nil {
+				t.Error(err)
+			}
+			//Gaps are signed as the fill byte, so changing the fill changes the digest
+			if err := verifyMemory(mem, signed, 0x100C, key, 0x00); err == nil {
+				t.Error("Should fail verification with a different fill")
+			}
+			mem.SetBinary(0x1001, []byte{0xAA})
+			if err := verifyMemory(mem, signed, 0x100C, key, 0xFF); err == nil {
+				t.Error("Should fail verification of a modified image")
+			}
+			if err := verifyMemory(mem, signed, 0x2000, key, 0xFF); err == nil {
+				t.Error("Should fail verification without a signature")
+			}
+		})
+	}
+}
+
+func TestRunVerifySmallImage(t *testing.T) {
+	t.Parallel()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath, publicPath := writeTestKeys(t, key)
+	defer os.Remove(privatePath)
+	defer os.Remove(publicPath)
+	tmpfile, err := os.CreateTemp("", "*_tiny.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Write(make([]byte, 16))
+	tmpfile.Close()
+	//An image smaller than the signature can't hold one, at any address
+	for _, address := range []string{"0x08000000", "0"} {
+		if err := runVerify([]string{"--key=" + publicPath, tmpfile.Name() + ":" + address}); err == nil {
+			t.Errorf("Should raise error on a 16 byte image @ %s", address)
+		}
+	}
+}
+
+func TestSignAtEndOfAddressSpace(t *testing.T) {
+	t.Parallel()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath, publicPath := writeTestKeys(t, key)
+	defer os.Remove(privatePath)
+	defer os.Remove(publicPath)
+	sign, err := parseSignOption(privatePath+":*", &signSettings{fill: 0xFF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := gohex.NewMemory()
+	mem.AddBinary(0xFFFFFFF0, make([]byte, 16))
+	if err := sign.apply(mem); err == nil {
+		t.Error("Should raise error with no room for the signature after the image")
+	}
+	if segments := mem.GetDataSegments(); len(segments) != 1 {
+		t.Errorf("Signature should not wrap to address 0, got %d segments", len(segments))
+	}
+}
+
+func TestLoadKeyErrors(t *testing.T) {
+	t.Parallel()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath, publicPath := writeTestKeys(t, ecKey)
+	defer os.Remove(privatePath)
+	defer os.Remove(publicPath)
+	if _, err := loadPrivateKey(privatePath); err == nil {
+		t.Error("Should raise error on a P-384 key")
+	}
+	if _, err := loadPublicKey(publicPath); err == nil {
+		t.Error("Should raise error on a P-384 key")
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPrivatePath, rsaPublicPath := writeTestKeys(t, rsaKey)
+	defer os.Remove(rsaPrivatePath)
+	defer os.Remove(rsaPublicPath)
+	if _, err := loadPrivateKey(rsaPrivatePath); err == nil {
+		t.Error("Should raise error on a 1024 bit RSA key")
+	}
+	if _, err := loadPublicKey(rsaPublicPath); err == nil {
+		t.Error("Should raise error on a 1024 bit RSA key")
+	}
+	if _, err := loadPrivateKey("/not/a/key.pem"); err == nil {
+		t.Error("Should raise error on missing key")
+	}
+	tmpfile, err := os.CreateTemp("", "*_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString("not a key")
+	tmpfile.Close()
+	if _, err := loadPublicKey(tmpfile.Name()); err == nil {
+		t.Error("Should raise error on a file without PEM data")
+	}
+}
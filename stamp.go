@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//stampSettings are shared by every --stamp, so --stamp-force and --stamp-fill apply wherever they are given
+type stampSettings struct {
+	force bool
+	fill  byte
+}
+
+//parseStampOption parses ADDRESS:TYPE:VALUE
+//TYPE is u8/u16/u32/u64 with an optional le or be suffix (default le), strN for a NUL padded N byte string or bytes for hex data
+//VALUE is taken literally, or from env:NAME or file:PATH
+func parseStampOption(value string, settings *stampSettings) (transform, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 {
+		return transform{}, fmt.Errorf("should be ADDRESS:TYPE:VALUE")
+	}
+	address, err := parseNumberString(parts[0])
+	if err != nil {
+		return transform{}, err
+	}
+	kind, err := parseStampType(parts[1])
+	if err != nil {
+		return transform{}, err
+	}
+	source := parts[2]
+	return transform{
+		name: fmt.Sprintf("stamp %s from %s @ 0x%08X", kind.kind, source, address),
+		apply: func(mem *gohex.Memory) error {
+			text, err := stampValue(source)
+			if err != nil {
+				return err
+			}
+			data, err := kind.encode(text)
+			if err != nil {
+				return fmt.Errorf("stamp @ 0x%08X => %v", address, err)
+			}
+			return stampMemory(mem, address, data, settings.fill, settings.force)
+		},
+	}, nil
+}
+
+//stampValue resolves where a value comes from
+func stampValue(source string) (string, error) {
+	if strings.HasPrefix(source, "env:") {
+		value, ok := os.LookupEnv(source[4:])
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", source[4:])
+		}
+		return value, nil
+	}
+	if strings.HasPrefix(source, "file:") {
+		data, err := ioutil.ReadFile(source[5:])
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return source, nil
+}
+
+//stampType is the layout a stamped value is written in
+type stampType struct {
+	kind   string
+	bits   int              //Integer width, 0 for strings and bytes
+	order  binary.ByteOrder //Integer byte order
+	length int              //Fixed length of strings
+}
+
+//parseStampType parses the TYPE part of --stamp
+func parseStampType(kind string) (stampType, error) {
+	t := stampType{kind: kind, order: binary.LittleEndian}
+	switch {
+	case kind == "bytes":
+		return t, nil
+	case strings.HasPrefix(kind, "str"):
+		length, err := strconv.Atoi(kind[3:])
+		if err != nil || length < 1 {
+			return t, fmt.Errorf("unknown string type %s, should be strN", kind)
+		}
+		t.length = length
+		return t, nil
+	case strings.HasPrefix(kind, "u"):
+		bitsText := kind[1:]
+		if strings.HasSuffix(bitsText, "be") {
+			t.order = binary.BigEndian
+			bitsText = strings.TrimSuffix(bitsText, "be")
+		} else {
+			bitsText = strings.TrimSuffix(bitsText, "le")
+		}
+		bits, err := strconv.Atoi(bitsText)
+		if err != nil || (bits != 8 && bits != 16 && bits != 32 && bits != 64) {
+			return t, fmt.Errorf("unknown integer type %s", kind)
+		}
+		t.bits = bits
+		return t, nil
+	}
+	return t, fmt.Errorf("unknown stamp type %s", kind)
+}
+
+//encode converts the text value into the bytes to write
+func (t stampType) encode(text string) ([]byte, error) {
+	if t.bits > 0 {
+		n, err := strconv.ParseUint(strings.TrimSpace(text), 0, t.bits)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 8)
+		t.order.PutUint64(data, n)
+		if t.order == binary.BigEndian {
+			return data[8-t.bits/8:], nil
+		}
+		return data[:t.bits/8], nil
+	}
+	if t.length > 0 {
+		if len(text) > t.length {
+			return nil, fmt.Errorf("string %q is longer than %d bytes", text, t.length)
+		}
+		data := make([]byte, t.length)
+		copy(data, text)
+		return data, nil
+	}
+	return hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(text), "0x"))
+}
+
+//stampMemory writes the data at the address, refusing to replace anything other than the fill byte unless forced
+func stampMemory(mem *gohex.Memory, address uint32, data []byte, fill byte, force bool) error {
+	r := addressRange{start: uint64(address), end: uint64(address) + uint64(len(data))}
+	if r.end > 1<<32 {
+		return fmt.Errorf("stamp @ 0x%08X runs past the end of the address space", address)
+	}
+	if !force {
+		if existing, b, found := findData(mem, r, fill); found {
+			return fmt.Errorf("stamp would overwrite data 0x%02X @ 0x%08X, use --stamp-force to allow", b, existing)
+		}
+	}
+	mem.SetBinary(address, data)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestStampEncode(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		kind    string
+		text    string
+		want    []byte
+		wantErr bool
+	}{
+		{"u8", "0x12", []byte{0x12}, false},
+		{"u16", "0x1234", []byte{0x34, 0x12}, false},
+		{"u16be", "0x1234", []byte{0x12, 0x34}, false},
+		{"u32le", "305419896", []byte{0x78, 0x56, 0x34, 0x12}, false},
+		{"u64be", "0x0102030405060708", []byte{1, 2, 3, 4, 5, 6, 7, 8}, false},
+		{"u8", "256", nil, true},
+		{"str8", "v1.2", []byte{'v', '1', '.', '2', 0, 0, 0, 0}, false},
+		{"str2", "v1.2", nil, true},
+		{"bytes", "0xDEADBEEF", []byte{0xDE, 0xAD, 0xBE, 0xEF}, false},
+		{"bytes", "a1b2", []byte{0xA1, 0xB2}, false},
+		{"bytes", "a1b", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind+"-"+tt.text, func(t *testing.T) {
+			kind, err := parseStampType(tt.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := kind.encode(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(data, tt.want) {
+				t.Errorf("got %v, want %v", data, tt.want)
+			}
+		})
+	}
+	for _, kind := range []string{"u12", "u", "str", "str0", "float"} {
+		if _, err := parseStampType(kind); err == nil {
+			t.Errorf("Should raise error on type %s", kind)
+		}
+	}
+}
+
+func TestStampValue(t *testing.T) {
+	os.Setenv("HEXM_TEST_STAMP", "1.2.3")
+	defer os.Unsetenv("HEXM_TEST_STAMP")
+	tmpfile, err := os.CreateTemp("", "*_stamp.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString("abcdef\n")
+	tmpfile.Close()
+
+	var tests = []struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{"0x1234", "0x1234", false},
+		{"env:HEXM_TEST_STAMP", "1.2.3", false},
+		{"env:HEXM_TEST_NOT_SET", "", true},
+		{"file:" + tmpfile.Name(), "abcdef", false},
+		{"file:/not/a/file", "", true},
+	}
+	for _, tt := range tests {
+		value, err := stampValue(tt.source)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s got error %v, want error %v", tt.source, err, tt.wantErr)
+		}
+		if value != tt.want {
+			t.Errorf("%s got %v, want %v", tt.source, value, tt.want)
+		}
+	}
+}
+
+func TestStampMemory(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x100, []byte{0xFF, 0xFF, 0xFF, 0x01})
+	err := stampMemory(mem, 0x101, []byte{0xAA, 0xBB}, 0xFF, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stampMemory(mem, 0x102, []byte{0xCC, 0xDD}, 0xFF, false)
+	if err == nil {
+		t.Fatal("Should refuse to overwrite existing data")
+	}
+	err = stampMemory(mem, 0x102, []byte{0xCC, 0xDD}, 0xFF, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Stamping into a gap is always allowed
+	err = stampMemory(mem, 0x104, []byte{0xEE}, 0xFF, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xFF, 0xAA, 0xCC, 0xDD, 0xEE}
+	if !reflect.DeepEqual(mem.GetDataSegments()[0].Data, want) {
+		t.Errorf("got %v, want %v", mem.GetDataSegments()[0].Data, want)
+	}
+}
+
+func TestStampOptionsShareSettings(t *testing.T) {
+	t.Parallel()
+	settings, err := parseMergeOptions([]option{{"stamp", "0x100:u8:1"}, {"stamp-force", ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x100, []byte{0x00})
+	if err := settings.transforms[0].apply(mem); err != nil {
+		t.Errorf("--stamp-force after --stamp should still apply, got %v", err)
+	}
+	_, err = parseMergeOptions([]option{{"stamp", "0x100:u9:1"}})
+	if err == nil {
+		t.Error("Should raise error on bad stamp type")
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	hexDataRecord     = 0x00
+	hexEOFRecord      = 0x01
+	hexSegmentRecord  = 0x02
+	hexLinearRecord   = 0x04
+	hexStartRecord    = 0x05
+	hexLineLength     = 32
+	streamChunkLength = 1024 * 1024
+)
+
+//hexWriter writes Intel HEX records as data arrives, by default laid out the same way as gohex's DumpIntelHex
+//Lines are lineLength bytes from the start of each run of data and break where the upper 16 address bits change
+type hexWriter struct {
+	w           *bufio.Writer
+	started     bool
+	extended    uint32
+	lineAddress uint32
+	line        []byte
+	record      []byte
+	ending      string
+	lineLength  int
+	aligned     bool //Lines start on multiples of lineLength rather than at the start of each run
+}
+
+func newHexWriter(w io.Writer) *hexWriter {
+	return &hexWriter{w: bufio.NewWriterSize(w, 64*1024), line: make([]byte, 0, 255), ending: "\n", lineLength: hexLineLength}
+}
+
+//writeRecord writes one record with its checksum
+func (h *hexWriter) writeRecord(address uint16, recordType byte, data []byte) error {
+	const digits = "0123456789ABCDEF"
+	record := h.record[:0]
+	sum := byte(len(data)) + byte(address>>8) + byte(address) + recordType
+	record = append(record, ':')
+	for _, b := range []byte{byte(len(data)), byte(address >> 8), byte(address), recordType} {
+		record = append(record, digits[b>>4], digits[b&15])
+	}
+	for _, b := range data {
+		record = append(record, digits[b>>4], digits[b&15])
+		sum += b
+	}
+	record = append(record, digits[-sum>>4], digits[-sum&15])
+	record = append(record, h.ending...)
+	h.record = record
+	_, err := h.w.Write(record)
+	return err
+}
+
+func (h *hexWriter) flushLine() error {
+	if len(h.line) == 0 {
+		return nil
+	}
+	err := h.writeRecord(uint16(h.lineAddress), hexDataRecord, h.line)
+	h.line = h.line[:0]
+	return err
+}
+
+//writeStart writes the start linear address record, which has to come before any data to match gohex
+func (h *hexWriter) writeStart(address uint32) error {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, address)
+	return h.writeRecord(0, hexStartRecord, value)
+}
+
+//write adds data at address, which may follow on from the last write or start a new run
+func (h *hexWriter) write(address uint32, data []byte) error {
+	if len(h.line) > 0 && address != h.lineAddress+uint32(len(h.line)) {
+		if err := h.flushLine(); err != nil {
+			return err
+		}
+	}
+	for i, b := range data {
+		byteAddress := address + uint32(i)
+		if !h.started || byteAddress&0xFFFF0000 != h.extended {
+			if err := h.flushLine(); err != nil {
+				return err
+			}
+			h.started = true
+			h.extended = byteAddress & 0xFFFF0000
+			if err := h.writeRecord(0, hexLinearRecord, []byte{byte(h.extended >> 24), byte(h.extended >> 16)}); err != nil {
+				return err
+			}
+		}
+		if len(h.line) >= h.lineLength || (h.aligned && byteAddress%uint32(h.lineLength) == 0) {
+			if err := h.flushLine(); err != nil {
+				return err
+			}
+		}
+		if len(h.line) == 0 {
+			h.lineAddress = byteAddress
+		}
+		h.line = append(h.line, b)
+	}
+	return nil
+}
+
+//close writes the last line and the end of file record
+func (h *hexWriter) close() error {
+	if err := h.flushLine(); err != nil {
+		return err
+	}
+	if err := h.writeRecord(0, hexEOFRecord, nil); err != nil {
+		return err
+	}
+	return h.w.Flush()
+}
+
+//writeCanonicalHex writes the image so the same data always gives the same file, whatever produced it
+//Records hold recordLength bytes aligned to multiples of it, in address order, uppercase with LF endings and no start address
+func writeCanonicalHex(w io.Writer, img image, recordLength int) error {
+	h := newHexWriter(w)
+	h.lineLength, h.aligned = recordLength, true
+	if err := readChunks(img, img.ranges(), h.write); err != nil {
+		return err
+	}
+	return h.close()
+}
+
+//writeHex writes the whole image as Intel HEX, reading it a chunk at a time
+func writeHex(w io.Writer, img image) error {
+	h := newHexWriter(w)
+	if start, ok := img.startAddress(); ok {
+		if err := h.writeStart(start); err != nil {
+			return err
+		}
+	}
+	if err := readChunks(img, img.ranges(), h.write); err != nil {
+		return err
+	}
+	return h.close()
+}
+
+//hexRecord is one parsed line of an Intel HEX file
+type hexRecord struct {
+	line       int
+	address    uint16
+	recordType byte
+	data       []byte
+}
+
+//hexReader reads Intel HEX records one line at a time, checking them the same way gohex does
+type hexReader struct {
+	scanner  *bufio.Scanner
+	line     int
+	extended uint32
+	eof      bool
+	start    uint32 //From a type 05 record, 03 CS:IP records are ignored as gohex does
+	hasStart bool
+}
+
+func newHexReader(r io.Reader) *hexReader {
+	return &hexReader{scanner: bufio.NewScanner(r)}
+}
+
+func parseHexRecord(text string, line int) (hexRecord, error) {
+	if text[0] != ':' {
+		return hexRecord{}, fmt.Errorf("line %d does not start with ':'", line)
+	}
+	raw, err := hex.DecodeString(text[1:])
+	if err != nil {
+		return hexRecord{}, fmt.Errorf("line %d => %v", line, err)
+	}
+	if len(raw) < 5 || int(raw[0])+5 != len(raw) {
+		return hexRecord{}, fmt.Errorf("line %d has the wrong length", line)
+	}
+	sum := byte(0)
+	for _, b := range raw {
+		sum += b
+	}
+	if sum != 0 {
+		return hexRecord{}, fmt.Errorf("line %d has a bad checksum", line)
+	}
+	return hexRecord{line: line, address: binary.BigEndian.Uint16(raw[1:]), recordType: raw[3], data: raw[4 : len(raw)-1]}, nil
+}
+
+//next returns the address and data of the next data record, io.EOF after the end of file record
+func (r *hexReader) next() (uint32, []byte, error) {
+	for !r.eof && r.scanner.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" {
+			continue
+		}
+		record, err := parseHexRecord(text, r.line)
+		if err != nil {
+			return 0, nil, err
+		}
+		switch record.recordType {
+		case hexDataRecord:
+			return r.extended + uint32(record.address), record.data, nil
+		case hexEOFRecord:
+			r.eof = true
+		case hexSegmentRecord, hexLinearRecord:
+			if len(record.data) != 2 {
+				return 0, nil, fmt.Errorf("line %d has a bad extended address record", r.line)
+			}
+			shift := uint(4)
+			if record.recordType == hexLinearRecord {
+				shift = 16
+			}
+			r.extended = uint32(binary.BigEndian.Uint16(record.data)) << shift
+		case hexStartRecord:
+			if len(record.data) != 4 {
+				return 0, nil, fmt.Errorf("line %d has a bad start address record", r.line)
+			}
+			if r.hasStart {
+				return 0, nil, fmt.Errorf("line %d is a second start address record", r.line)
+			}
+			r.start, r.hasStart = binary.BigEndian.Uint32(record.data), true
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	if !r.eof {
+		return 0, nil, fmt.Errorf("no end of file record")
+	}
+	return 0, nil, io.EOF
+}
+
+//rangeSet tracks which addresses have been written so overlapping records are caught without keeping the data
+type rangeSet struct {
+	ranges []addressRange
+}
+
+//add records the range, returning false if any of it was already there
+func (s *rangeSet) add(r addressRange) bool {
+	i := sort.Search(len(s.ranges), func(i int) bool { return s.ranges[i].end >= r.start })
+	if i < len(s.ranges) && s.ranges[i].start < r.end && r.start < s.ranges[i].end {
+		return false
+	}
+	if i < len(s.ranges) && s.ranges[i].end == r.start {
+		if i+1 < len(s.ranges) && s.ranges[i+1].start < r.end {
+			return false
+		}
+		s.ranges[i].end = r.end
+		if i+1 < len(s.ranges) && s.ranges[i+1].start == r.end {
+			s.ranges[i].end = s.ranges[i+1].end
+			s.ranges = append(s.ranges[:i+1], s.ranges[i+2:]...)
+		}
+		return true
+	}
+	if i < len(s.ranges) && s.ranges[i].start == r.end {
+		s.ranges[i].start = r.start
+		return true
+	}
+	s.ranges = append(s.ranges, addressRange{})
+	copy(s.ranges[i+1:], s.ranges[i:])
+	s.ranges[i] = r
+	return true
+}
+
+//canStream returns if the conversion can run without loading the image, which needs a single hex or bin input,
+//a hex or bin output of the other type and no options that change the image
+func canStream(settings mergeOptions, inputs []string, output string) bool {
+	if len(inputs) != 1 || len(settings.transforms) > 0 || settings.splitLanes.lanes > 0 || settings.combineLanes.lanes > 0 ||
+		settings.device != nil || settings.lenient || settings.dryRun || settings.report != "" || settings.output != (outputOptions{}) {
+		return false
+	}
+	inputHex, _, inputPath, err := parseFileTypeAndStart(inputs[0])
+	if err != nil || isSegmentIndex(inputPath) {
+		return false
+	}
+	outputHex, _, outputPath, err := parseFileTypeAndStart(output)
+	if err != nil || isSegmentIndex(outputPath) {
+		return false
+	}
+	return inputHex != outputHex
+}
+
+//streamConvert converts between bin and hex holding at most a chunk of the image in memory
+func streamConvert(input, output string) error {
+	inputHex, inputStart, inputPath, err := parseFileTypeAndStart(input)
+	if err != nil {
+		return err
+	}
+	_, outputStart, outputPath, err := parseFileTypeAndStart(output)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if inputHex {
+		return streamHexToBin(in, out, outputStart)
+	}
+	return streamBinToHex(in, out, inputStart)
+}
+
+//streamBinToHex writes the bin as hex records starting at address
+func streamBinToHex(in io.Reader, out io.Writer, address uint32) error {
+	h := newHexWriter(out)
+	chunk := make([]byte, streamChunkLength)
+	length := uint64(0)
+	for {
+		n, err := io.ReadFull(in, chunk)
+		if uint64(address)+length+uint64(n) > 1<<32 {
+			return fmt.Errorf("bin @ 0x%08X runs past the end of the address space", address)
+		}
+		if n > 0 {
+			if err := h.write(address+uint32(length), chunk[:n]); err != nil {
+				return err
+			}
+			length += uint64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Streamed %d bytes @ %08X\n", length, address)
+	return h.close()
+}
+
+//streamHexToBin writes each run of records to the bin as it is read, data before start is dropped as in writeBinary
+func streamHexToBin(in io.Reader, out *os.File, start uint32) error {
+	r := newHexReader(in)
+	written := &rangeSet{}
+	run := make([]byte, 0, streamChunkLength)
+	runStart := uint32(0)
+	flush := func() error {
+		if len(run) == 0 {
+			return nil
+		}
+		_, err := out.WriteAt(run, int64(runStart-start))
+		runStart += uint32(len(run))
+		run = run[:0]
+		return err
+	}
+	total := uint64(0)
+	for {
+		address, data, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if uint64(address)+uint64(len(data)) > 1<<32 {
+			return fmt.Errorf("line %d runs past the end of the address space", r.line)
+		}
+		if !written.add(addressRange{start: uint64(address), end: uint64(address) + uint64(len(data))}) {
+			return fmt.Errorf("line %d overlaps data already read", r.line)
+		}
+		if address < start {
+			if uint64(address)+uint64(len(data)) <= uint64(start) {
+				continue
+			}
+			data = data[start-address:]
+			address = start
+		}
+		if len(run) == 0 || address != runStart+uint32(len(run)) || len(run)+len(data) > cap(run) {
+			if err := flush(); err != nil {
+				return err
+			}
+			if address != runStart {
+				if err := checkFileStartPos(out, address-start); err != nil {
+					return err
+				}
+			}
+			runStart = address
+		}
+		run = append(run, data...)
+		total += uint64(len(data))
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	fmt.Printf("Streamed %d bytes in %d runs\n", total, len(written.ranges))
+	return nil
+}
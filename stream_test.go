@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestWriteHexMatchesGohex(t *testing.T) {
+	t.Parallel()
+	pattern := func(length int) []byte {
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = byte(i * 13)
+		}
+		return data
+	}
+	var tests = []struct {
+		name     string
+		segments map[uint32][]byte
+		start    bool
+	}{
+		{"empty", map[uint32][]byte{}, false},
+		{"short", map[uint32][]byte{0: {1, 2, 3}}, false},
+		{"unaligned", map[uint32][]byte{0x1003: pattern(100)}, false},
+		{"crosses 64K", map[uint32][]byte{0x0800FFF0: pattern(0x40)}, false},
+		{"segments", map[uint32][]byte{0x100: pattern(40), 0x200: pattern(3), 0x90000000: pattern(70)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := gohex.NewMemory()
+			for address, data := range tt.segments {
+				mem.AddBinary(address, data)
+			}
+			if tt.start {
+				mem.SetStartAddress(0x08000101)
+			}
+			var want, got bytes.Buffer
+			if err := mem.DumpIntelHex(&want, hexLineLength); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeHex(&got, memoryImage{mem}); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("got\n%s\nwant\n%s", got.String(), want.String())
+			}
+		})
+	}
+}
+
+func TestHexReader(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x0800FFF0, bytes.Repeat([]byte{0xA5}, 0x30))
+	var image bytes.Buffer
+	writeHex(&image, memoryImage{mem})
+	r := newHexReader(&image)
+	read := gohex.NewMemory()
+	for {
+		address, data, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		read.AddBinary(address, data)
+	}
+	if !reflect.DeepEqual(read.GetDataSegments(), mem.GetDataSegments()) {
+		t.Errorf("got %v", read.GetDataSegments())
+	}
+	for _, bad := range []string{
+		":0100000001FE\n",                  //No end of file
+		"0100000001FE\n:00000001FF\n",      //No colon
+		":0100000001FF\n:00000001FF\n",     //Bad checksum
+		":020000000102FB00\n:00000001FF\n", //Wrong length
+		":0100000001FE\n:0100000001FE\n:00000001FF\n",
+	} {
+		r := newHexReader(strings.NewReader(bad))
+		var err error
+		written := &rangeSet{}
+		for err == nil {
+			var address uint32
+			var data []byte
+			address, data, err = r.next()
+			if err == nil && !written.add(addressRange{uint64(address), uint64(address) + uint64(len(data))}) {
+				err = io.ErrShortWrite
+			}
+		}
+		if err == io.EOF {
+			t.Errorf("Should raise error on %q", bad)
+		}
+	}
+}
+
+func TestRangeSet(t *testing.T) {
+	t.Parallel()
+	s := &rangeSet{}
+	for _, r := range []addressRange{{10, 20}, {30, 40}, {20, 30}, {0, 5}, {5, 10}, {50, 60}} {
+		if !s.add(r) {
+			t.Fatalf("%v should not overlap", r)
+		}
+	}
+	if want := []addressRange{{0, 40}, {50, 60}}; !reflect.DeepEqual(s.ranges, want) {
+		t.Errorf("got %v, want %v", s.ranges, want)
+	}
+	for _, r := range []addressRange{{39, 41}, {45, 51}, {0, 1}, {40, 55}, {59, 70}} {
+		if s.add(r) {
+			t.Errorf("%v should overlap", r)
+		}
+	}
+}
+
+func TestStreamConvert(t *testing.T) {
+	t.Parallel()
+	data := make([]byte, 3*streamChunkLength/2)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	binFile, err := os.CreateTemp("", "test_*.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binFile.Write(data)
+	binFile.Close()
+	defer os.Remove(binFile.Name())
+	hexPath := binFile.Name() + ".hex"
+	outPath := binFile.Name() + ".out.bin"
+	defer os.Remove(hexPath)
+	defer os.Remove(outPath)
+
+	//bin -> hex matches the in memory writer
+	if err := streamConvert(binFile.Name()+":0x0800F000", hexPath); err != nil {
+		t.Fatal(err)
+	}
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x0800F000, data)
+	var want bytes.Buffer
+	writeHex(&want, memoryImage{mem})
+	got, err := ioutil.ReadFile(hexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("Streamed hex does not match writeHex")
+	}
+
+	//hex -> bin, dropping data before the start address
+	if err := streamConvert(hexPath, outPath+":0x08010000"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[0x1000:]) {
+		t.Errorf("Streamed bin is %d bytes, want %d", len(got), len(data)-0x1000)
+	}
+}
+
+func TestCanStream(t *testing.T) {
+	t.Parallel()
+	fill, _ := parseFillOption("0xFF", false)
+	var tests = []struct {
+		settings mergeOptions
+		inputs   []string
+		output   string
+		want     bool
+	}{
+		{mergeOptions{}, []string{"a.bin:0x100"}, "b.hex", true},
+		{mergeOptions{}, []string{"a.hex"}, "b.bin:0x100", true},
+		{mergeOptions{}, []string{"a.hex"}, "b.hex", false},
+		{mergeOptions{}, []string{"a.hex", "b.hex"}, "c.bin", false},
+		{mergeOptions{}, []string{"a.hex"}, "c.json", false},
+		{mergeOptions{transforms: []transform{fill}}, []string{"a.hex"}, "c.bin", false},
+		{mergeOptions{output: outputOptions{padByte: 0xFF}}, []string{"a.hex"}, "c.bin", false},
+	}
+	for _, tt := range tests {
+		if got := canStream(tt.settings, tt.inputs, tt.output); got != tt.want {
+			t.Errorf("%v -> %s got %v, want %v", tt.inputs, tt.output, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkStreamBinToHex(b *testing.B) {
+	data := make([]byte, 16*streamChunkLength)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := streamBinToHex(bytes.NewReader(data), ioutil.Discard, 0x08000000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamHexToBin(b *testing.B) {
+	data := make([]byte, 16*streamChunkLength)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	var image bytes.Buffer
+	streamBinToHex(bytes.NewReader(data), &image, 0x08000000)
+	out, err := os.CreateTemp("", "bench_*.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := streamHexToBin(bytes.NewReader(image.Bytes()), out, 0x08000000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriteCanonicalHex(t *testing.T) {
+	t.Parallel()
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	//The same content built two ways
+	a := gohex.NewMemory()
+	a.AddBinary(0x0800FFF5, data)
+	a.SetStartAddress(0x08000101)
+	b := gohex.NewMemory()
+	b.AddBinary(0x08010000, data[11:])
+	b.AddBinary(0x0800FFF5, data[:11])
+	var outA, outB bytes.Buffer
+	if err := writeCanonicalHex(&outA, memoryImage{a}, 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCanonicalHex(&outB, memoryImage{b}, 16); err != nil {
+		t.Fatal(err)
+	}
+	if outA.String() != outB.String() {
+		t.Errorf("Same content should give the same file\n%s\n%s", outA.String(), outB.String())
+	}
+	want := []string{
+		hexLine(0, hexLinearRecord, 0x08, 0x00),
+		hexLine(0xFFF5, hexDataRecord, data[:11]...),
+		hexLine(0, hexLinearRecord, 0x08, 0x01),
+		hexLine(0x0000, hexDataRecord, data[11:27]...),
+	}
+	lines := strings.Split(outA.String(), "\n")
+	for i, line := range want {
+		if lines[i] != line {
+			t.Errorf("line %d got %s, want %s", i+1, lines[i], line)
+		}
+	}
+	if strings.Contains(outA.String(), hexLine(0, hexStartRecord, 0x08, 0x00, 0x01, 0x01)) {
+		t.Errorf("Start address should be left out")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marcinbor85/gohex"
+)
+
+//parseSwapOption parses WIDTH[:RANGE[:PAD]] where width is in bits
+//When PAD is given segments that are not word aligned are padded with that byte rather than raising an error
+func parseSwapOption(value string) (transform, error) {
+	parts := strings.SplitN(value, ":", 3)
+	bits, err := parseNumberString(parts[0])
+	if err != nil {
+		return transform{}, err
+	}
+	if bits != 16 && bits != 32 && bits != 64 {
+		return transform{}, fmt.Errorf("word width must be 16, 32 or 64 bits")
+	}
+	r := fullAddressRange
+	if len(parts) > 1 {
+		r, err = parseAddressRange(parts[1])
+		if err != nil {
+			return transform{}, err
+		}
+	}
+	pad := false
+	fill := byte(0)
+	if len(parts) > 2 {
+		pad = true
+		fill, err = parseByte(parts[2])
+		if err != nil {
+			return transform{}, err
+		}
+	}
+	return transform{
+		name: fmt.Sprintf("swap %d bit words over %v", bits, r),
+		apply: func(mem *gohex.Memory) error {
+			return byteSwap(mem, bits/8, r, pad, fill)
+		},
+	}, nil
+}
+
+//byteSwap reverses the byte order of every width byte word inside the range
+//Segments that don't start and end on a word boundary are an error unless pad is set,
+//in which case the missing bytes around them are filled in first
+func byteSwap(mem *gohex.Memory, width uint32, r addressRange, pad bool, fill byte) error {
+	w := uint64(width)
+	if r != fullAddressRange && (r.start%w != 0 || r.end%w != 0) {
+		return fmt.Errorf("range %v is not aligned to %d bytes", r, width)
+	}
+	for _, segment := range mem.GetDataSegments() {
+		address, data, ok := r.clip(segment)
+		if !ok {
+			continue
+		}
+		start := uint64(address)
+		end := start + uint64(len(data))
+		if start%w == 0 && end%w == 0 {
+			continue
+		}
+		if !pad {
+			return fmt.Errorf("segment @ 0x%08X ; len %d is not aligned to %d bytes", address, len(data), width)
+		}
+		padding := addressRange{start: alignDown(start, w), end: alignUp(end, w)}
+		if err := fillGaps(mem, padding, fill); err != nil {
+			return err
+		}
+	}
+	//Segments may have been padded above, so fetch them again before swapping in place
+	for _, segment := range mem.GetDataSegments() {
+		_, data, ok := r.clip(segment)
+		if !ok {
+			continue
+		}
+		for i := 0; i+int(width) <= len(data); i += int(width) {
+			word := data[i : i+int(width)]
+			for a, b := 0, len(word)-1; a < b; a, b = a+1, b-1 {
+				word[a], word[b] = word[b], word[a]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/marcinbor85/gohex"
+)
+
+func TestByteSwap(t *testing.T) {
+	t.Parallel()
+	data := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	var tests = []struct {
+		width uint32
+		r     addressRange
+		want  []byte
+	}{
+		{2, fullAddressRange, []byte{1, 0, 3, 2, 5, 4, 7, 6, 9, 8, 11, 10, 13, 12, 15, 14}},
+		{4, fullAddressRange, []byte{3, 2, 1, 0, 7, 6, 5, 4, 11, 10, 9, 8, 15, 14, 13, 12}},
+		{8, fullAddressRange, []byte{7, 6, 5, 4, 3, 2, 1, 0, 15, 14, 13, 12, 11, 10, 9, 8}},
+		{4, addressRange{0x104, 0x108}, []byte{0, 1, 2, 3, 7, 6, 5, 4, 8, 9, 10, 11, 12, 13, 14, 15}},
+	}
+
+	for _, tt := range tests {
+		testname := fmt.Sprintf("%d-%v", tt.width, tt.r)
+		t.Run(testname, func(t *testing.T) {
+			mem := gohex.NewMemory()
+			mem.AddBinary(0x100, append([]byte{}, data...))
+			err := byteSwap(mem, tt.width, tt.r, false, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(mem.GetDataSegments()[0].Data, tt.want) {
+				t.Errorf("got %v, want %v", mem.GetDataSegments()[0].Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestByteSwapUnaligned(t *testing.T) {
+	t.Parallel()
+	mem := gohex.NewMemory()
+	mem.AddBinary(0x101, []byte{1, 2, 3, 4})
+	err := byteSwap(mem, 4, fullAddressRange, false, 0)
+	if err == nil {
+		t.Fatal("Should raise error on unaligned segment")
+	}
+	err = byteSwap(mem, 4, addressRange{0x101, 0x105}, false, 0)
+	if err == nil {
+		t.Fatal("Should raise error on unaligned range")
+	}
+	err = byteSwap(mem, 4, fullAddressRange, true, 0xFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := mem.GetDataSegments()
+	want := []byte{3, 2, 1, 0xFF, 0xFF, 0xFF, 0xFF, 4}
+	if len(segments) != 1 || segments[0].Address != 0x100 || !reflect.DeepEqual(segments[0].Data, want) {
+		t.Errorf("got %v, want %v", segments, want)
+	}
+}
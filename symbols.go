@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//symbol is a named span of memory, either a section or a symbol inside one
+type symbol struct {
+	name    string
+	address uint32
+	size    uint32
+}
+
+func (s symbol) end() uint64 {
+	return uint64(s.address) + uint64(s.size)
+}
+
+//symbolTable annotates addresses with the sections and symbols that own them
+type symbolTable struct {
+	sections []symbol
+	symbols  []symbol
+	//The furthest end of the sorted spans up to each index, so range lookups can binary search for the first overlap
+	sectionReach []uint64
+	symbolReach  []uint64
+}
+
+//loadSymbols reads an ELF file, or a GNU ld map file when it ends in .map
+func loadSymbols(path string) (*symbolTable, error) {
+	table := &symbolTable{}
+	var err error
+	if strings.ToLower(filepath.Ext(path)) == ".map" {
+		err = table.loadLinkerMap(path)
+	} else {
+		err = table.loadELF(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load symbols from %s => %v", path, err)
+	}
+	table.finish()
+	return table, nil
+}
+
+//merge adds the sections and symbols of another table
+func (t *symbolTable) merge(other *symbolTable) {
+	t.sections = append(t.sections, other.sections...)
+	t.symbols = append(t.symbols, other.symbols...)
+	t.finish()
+}
+
+func (t *symbolTable) loadELF(path string) error {
+	file, err := elf.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, section := range file.Sections {
+		if section.Flags&elf.SHF_ALLOC != 0 && section.Size > 0 {
+			t.sections = append(t.sections, symbol{name: section.Name, address: uint32(section.Addr), size: uint32(section.Size)})
+			//As with map files, sections loaded somewhere other than where they run are also added at the load address
+			if load, ok := elfLoadAddress(file, section); ok && load != uint32(section.Addr) {
+				t.sections = append(t.sections, symbol{name: section.Name + " (load)", address: load, size: uint32(section.Size)})
+			}
+		}
+	}
+	symbols, err := file.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return err
+	}
+	for _, s := range symbols {
+		kind := elf.ST_TYPE(s.Info)
+		if (kind != elf.STT_FUNC && kind != elf.STT_OBJECT) || s.Name == "" {
+			continue
+		}
+		address := uint32(s.Value)
+		if file.Type == elf.ET_REL && int(s.Section) < len(file.Sections) {
+			//Relocatable objects hold symbols as offsets into their section
+			address += uint32(file.Sections[s.Section].Addr)
+		}
+		if kind == elf.STT_FUNC && file.Machine == elf.EM_ARM {
+			//Thumb functions have the low bit set to mark the instruction set
+			address &^= 1
+		}
+		t.symbols = append(t.symbols, symbol{name: s.Name, address: address, size: uint32(s.Size)})
+	}
+	return nil
+}
+
+//elfLoadAddress finds where a section's contents are in the image from the PT_LOAD segment holding it
+func elfLoadAddress(file *elf.File, section *elf.Section) (uint32, bool) {
+	if section.Type == elf.SHT_NOBITS {
+		return 0, false //Nothing is loaded for .bss
+	}
+	for _, program := range file.Progs {
+		if program.Type != elf.PT_LOAD || section.Addr < program.Vaddr || section.Addr+section.Size > program.Vaddr+program.Filesz {
+			continue
+		}
+		return uint32(program.Paddr + section.Addr - program.Vaddr), true
+	}
+	return 0, false
+}
+
+//loadLinkerMap picks output sections and symbols out of the memory map part of a GNU ld map file
+//Output sections start in the first column, symbols are an indented address followed by only a name
+func (t *symbolTable) loadLinkerMap(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	inMap := false
+	pendingSection := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !inMap {
+			inMap = strings.HasPrefix(line, "Linker script and memory map")
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if line[0] == '.' {
+			if len(fields) == 1 {
+				//Long section names put the address and size on the following line
+				pendingSection = fields[0]
+				continue
+			}
+			t.addMapSection(fields)
+			pendingSection = ""
+			continue
+		}
+		if pendingSection != "" {
+			t.addMapSection(append([]string{pendingSection}, fields...))
+			pendingSection = ""
+			continue
+		}
+		//Assignments, input sections and fill all have more than two fields
+		if len(fields) == 2 && strings.HasPrefix(fields[0], "0x") && !strings.HasPrefix(fields[1], "0x") {
+			if address, ok := parseMapAddress(fields[0]); ok {
+				t.symbols = append(t.symbols, symbol{name: fields[1], address: address})
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+//addMapSection adds an output section line of NAME ADDRESS SIZE [load address LMA]
+//Sections with a different load address are added at both, as the image holds them at the load address
+func (t *symbolTable) addMapSection(fields []string) {
+	if len(fields) < 3 {
+		return
+	}
+	address, okA := parseMapAddress(fields[1])
+	size, okS := parseMapAddress(fields[2])
+	if !okA || !okS || size == 0 {
+		return
+	}
+	t.sections = append(t.sections, symbol{name: fields[0], address: address, size: size})
+	if len(fields) >= 6 && fields[3] == "load" && fields[4] == "address" {
+		if load, ok := parseMapAddress(fields[5]); ok && load != address {
+			t.sections = append(t.sections, symbol{name: fields[0] + " (load)", address: load, size: size})
+		}
+	}
+}
+
+//parseMapAddress parses the 0x numbers in map files, which are 64 bits wide on 64 bit hosts
+func parseMapAddress(data string) (uint32, bool) {
+	n, err := strconv.ParseUint(data, 0, 64)
+	if err != nil || n > 0xFFFFFFFF {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+//finish sorts the table and gives unsized symbols a size reaching to the next symbol or the end of their section
+func (t *symbolTable) finish() {
+	sort.SliceStable(t.sections, func(i, j int) bool { return t.sections[i].address < t.sections[j].address })
+	sort.SliceStable(t.symbols, func(i, j int) bool { return t.symbols[i].address < t.symbols[j].address })
+	for i := range t.symbols {
+		if t.symbols[i].size != 0 {
+			continue
+		}
+		end := uint64(t.symbols[i].address) + 1
+		if section, ok := findSpan(t.sections, t.symbols[i].address); ok {
+			end = section.end()
+		}
+		for _, next := range t.symbols[i+1:] {
+			if next.address > t.symbols[i].address {
+				if uint64(next.address) < end {
+					end = uint64(next.address)
+				}
+				break
+			}
+		}
+		t.symbols[i].size = uint32(end - uint64(t.symbols[i].address))
+	}
+	t.sectionReach = spanReach(t.sections)
+	t.symbolReach = spanReach(t.symbols)
+}
+
+//spanReach returns the furthest end of the spans up to and including each index
+func spanReach(spans []symbol) []uint64 {
+	reach := make([]uint64, len(spans))
+	furthest := uint64(0)
+	for i, s := range spans {
+		if s.end() > furthest {
+			furthest = s.end()
+		}
+		reach[i] = furthest
+	}
+	return reach
+}
+
+//overlapping returns the sorted spans overlapping the range, in address order
+func overlapping(spans []symbol, reach []uint64, r addressRange) []symbol {
+	//Before the first index reaching past the range start nothing can overlap it
+	i := sort.Search(len(reach), func(i int) bool { return reach[i] > r.start })
+	found := []symbol{}
+	for ; i < len(spans) && uint64(spans[i].address) < r.end; i++ {
+		if spans[i].end() > r.start {
+			found = append(found, spans[i])
+		}
+	}
+	return found
+}
+
+//findSpan returns the last of the sorted spans that contains the address
+func findSpan(spans []symbol, address uint32) (symbol, bool) {
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].address > address })
+	for i--; i >= 0; i-- {
+		if uint64(address) < spans[i].end() {
+			return spans[i], true
+		}
+	}
+	return symbol{}, false
+}
+
+//describe names what owns the address, such as main+0x10 in .text, or "" if nothing is known
+func (t *symbolTable) describe(address uint32) string {
+	if t == nil {
+		return ""
+	}
+	section, inSection := findSpan(t.sections, address)
+	s, inSymbol := findSpan(t.symbols, address)
+	switch {
+	case inSymbol && inSection:
+		return fmt.Sprintf("%s in %s", offsetName(s, address), section.name)
+	case inSymbol:
+		return offsetName(s, address)
+	case inSection:
+		return offsetName(section, address)
+	}
+	return ""
+}
+
+func offsetName(s symbol, address uint32) string {
+	if address == s.address {
+		return s.name
+	}
+	return fmt.Sprintf("%s+0x%X", s.name, address-s.address)
+}
+
+//describeRange names everything owning part of the range, listing at most a few symbols
+func (t *symbolTable) describeRange(r addressRange) string {
+	if t == nil {
+		return ""
+	}
+	const limit = 4
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, s := range overlapping(t.symbols, t.symbolReach, r) {
+		add(s.name)
+	}
+	if len(names) == 0 {
+		for _, s := range overlapping(t.sections, t.sectionReach, r) {
+			add(s.name)
+		}
+	}
+	if len(names) > limit {
+		return fmt.Sprintf("%s and %d more", strings.Join(names[:limit], ", "), len(names)-limit)
+	}
+	return strings.Join(names, ", ")
+}
+
+//labels lists the names of sections and symbols starting inside the range, sections first
+func (t *symbolTable) labels(r addressRange) []string {
+	if t == nil {
+		return nil
+	}
+	names := []string{}
+	for _, spans := range [][]symbol{t.sections, t.symbols} {
+		i := sort.Search(len(spans), func(i int) bool { return uint64(spans[i].address) >= r.start })
+		for ; i < len(spans) && uint64(spans[i].address) < r.end; i++ {
+			names = append(names, spans[i].name)
+		}
+	}
+	return names
+}
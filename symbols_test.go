@@ -0,0 +1,185 @@
+package main
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+const testLinkerMap = `Archive member included to satisfy reference by file (symbol)
+
+Memory Configuration
+
+Name             Origin             Length             Attributes
+FLASH            0x0000000008000000 0x0000000000010000 xr
+
+Linker script and memory map
+
+.isr_vector     0x0000000008000000      0x100
+ *(.isr_vector)
+ .isr_vector    0x0000000008000000      0x100 startup.o
+                0x0000000008000000                g_pfnVectors
+
+.text           0x0000000008000100      0x200
+ *(.text .text.*)
+ .text.Reset_Handler
+                0x0000000008000100       0x40 startup.o
+                0x0000000008000100                Reset_Handler
+ .text.main     0x0000000008000140       0x80 main.o
+                0x0000000008000140                main
+                0x00000000080001c0                . = ALIGN (0x4)
+ *fill*         0x00000000080001c0       0x40 
+                0x0000000008000200                _etext = .
+
+.data           0x0000000020000000       0x10 load address 0x0000000008000300
+                0x0000000020000000                _sdata = .
+ .data          0x0000000020000000       0x10 main.o
+                0x0000000020000000                counter
+
+.a_very_long_section_name_for_testing
+                0x0000000008001000       0x20
+OUTPUT(firmware.elf elf32-littlearm)
+`
+
+func TestLoadLinkerMap(t *testing.T) {
+	t.Parallel()
+	tmpfile, err := os.CreateTemp("", "*_test.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString(testLinkerMap)
+	tmpfile.Close()
+	table, err := loadSymbols(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSections := []symbol{
+		{".isr_vector", 0x08000000, 0x100},
+		{".text", 0x08000100, 0x200},
+		{".data (load)", 0x08000300, 0x10},
+		{".a_very_long_section_name_for_testing", 0x08001000, 0x20},
+		{".data", 0x20000000, 0x10},
+	}
+	if !reflect.DeepEqual(table.sections, wantSections) {
+		t.Errorf("got %v, want %v", table.sections, wantSections)
+	}
+	var tests = []struct {
+		address uint32
+		want    string
+	}{
+		{0x08000000, "g_pfnVectors in .isr_vector"},
+		{0x08000104, "Reset_Handler+0x4 in .text"},
+		{0x08000150, "main+0x10 in .text"},
+		{0x080001F0, "main+0xB0 in .text"},
+		{0x08000304, ".data (load)+0x4"},
+		{0x20000008, "counter+0x8 in .data"},
+		{0x30000000, ""},
+	}
+	for _, tt := range tests {
+		if got := table.describe(tt.address); got != tt.want {
+			t.Errorf("0x%08X got %q, want %q", tt.address, got, tt.want)
+		}
+	}
+	if got := table.describeRange(addressRange{0x08000000, 0x08000150}); got != "g_pfnVectors, Reset_Handler, main" {
+		t.Errorf("got %q", got)
+	}
+	if got := table.describeRange(addressRange{0x08001000, 0x08001001}); got != ".a_very_long_section_name_for_testing" {
+		t.Errorf("Ranges without symbols should name the section, got %q", got)
+	}
+}
+
+func TestLoadELFSymbols(t *testing.T) {
+	t.Parallel()
+	binFile, hexFile := createTestFilePair(t, 64, 0)
+	defer os.Remove(binFile)
+	defer os.Remove(hexFile)
+	elfFile := binFile + ".elf"
+	cmd := exec.Command("objcopy", "-I", "binary", "-O", "elf32-i386",
+		"--rename-section", ".data=.text,alloc,load,contents,code",
+		"--change-section-address", ".data=0x08000000",
+		"--add-symbol", "Reset_Handler=.text:0x10,global,function",
+		"--add-symbol", "table=.text:0x20,global,object",
+		binFile, elfFile)
+	err := cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(elfFile)
+	table, err := loadSymbols(elfFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := table.describe(0x08000014); got != "Reset_Handler+0x4 in .text" {
+		t.Errorf("got %q", got)
+	}
+	if got := table.describe(0x0800003F); got != "table+0x1F in .text" {
+		t.Errorf("got %q", got)
+	}
+	if got := table.describe(0x08000004); got != ".text+0x4" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := loadSymbols(binFile); err == nil {
+		t.Error("Should raise error on a file that isn't ELF")
+	}
+}
+
+func TestELFLoadAddress(t *testing.T) {
+	t.Parallel()
+	//.data runs from RAM but is held in flash straight after .text
+	file := &elf.File{Progs: []*elf.Prog{
+		{ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0x08000000, Paddr: 0x08000000, Filesz: 0x100, Memsz: 0x100}},
+		{ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Vaddr: 0x20000000, Paddr: 0x08000100, Filesz: 0x20, Memsz: 0x40}},
+	}}
+	var tests = []struct {
+		name    string
+		section elf.SectionHeader
+		want    uint32
+		wantOk  bool
+	}{
+		{"text", elf.SectionHeader{Name: ".text", Type: elf.SHT_PROGBITS, Addr: 0x08000010, Size: 0x10}, 0x08000010, true},
+		{"data", elf.SectionHeader{Name: ".data", Type: elf.SHT_PROGBITS, Addr: 0x20000000, Size: 0x20}, 0x08000100, true},
+		{"bss", elf.SectionHeader{Name: ".bss", Type: elf.SHT_NOBITS, Addr: 0x20000020, Size: 0x20}, 0, false},
+		{"outside", elf.SectionHeader{Name: ".noinit", Type: elf.SHT_PROGBITS, Addr: 0x20001000, Size: 0x20}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := elfLoadAddress(file, &elf.Section{SectionHeader: tt.section})
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("got 0x%08X %v, want 0x%08X %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestDescribeRangeLongSpan(t *testing.T) {
+	t.Parallel()
+	//A long symbol starting well before the range still owns it, even with shorter ones between
+	table := &symbolTable{symbols: []symbol{{"blob", 0x100, 0x1000}, {"small", 0x200, 0x10}, {"after", 0x800, 0x10}}}
+	table.finish()
+	if got := table.describeRange(addressRange{0x400, 0x410}); got != "blob" {
+		t.Errorf("got %q", got)
+	}
+	if got := table.describeRange(addressRange{0x1FF, 0x801}); got != "blob, small, after" {
+		t.Errorf("got %q", got)
+	}
+	if got := table.labels(addressRange{0x1FF, 0x801}); !reflect.DeepEqual(got, []string{"small", "after"}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestDescribeOverlap(t *testing.T) {
+	t.Parallel()
+	table := &symbolTable{symbols: []symbol{{"main", 0x100, 0x10}, {"helper", 0x110, 0x10}}}
+	table.finish()
+	seg := addressRange{0x108, 0x118}
+	seg2 := addressRange{0x100, 0x120}
+	if got := describeOverlap(seg, seg2, table); got != "Overlaps existing data 0x00000108-0x00000118 ; main, helper" {
+		t.Errorf("got %q", got)
+	}
+	if got := describeOverlap(seg, seg2, nil); got != "Overlaps existing data 0x00000108-0x00000118" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -6,12 +6,10 @@ import (
 	"log"
 	"os"
 	"strings"
-
-	"github.com/marcinbor85/gohex"
 )
 
-func userConfirmOverlap(seg gohex.DataSegment, source string) bool {
-	return userConfirm(fmt.Sprintf("Merging segment @ 0x%08X from file %v will overwrite existing data, continue ?", seg.Address, source))
+func userConfirmOverlap(segment addressRange, source string) bool {
+	return userConfirm(fmt.Sprintf("Merging segment @ 0x%08X from file %v will overwrite existing data, continue ?", segment.start, source))
 }
 
 func userNumberInput(prompt string, defaultValue uint32) uint32 {
@@ -4,13 +4,11 @@ import (
 	"log"
 	"os"
 	"testing"
-
-	"github.com/marcinbor85/gohex"
 )
 
 func TestUserConfirmOverlap(t *testing.T) {
 	//basic test as its just a wrapper
-	seg := gohex.DataSegment{Address: 100, Data: []byte{1, 2, 3}}
+	seg := addressRange{100, 103}
 	tmpfile, err := os.CreateTemp("", "mockstdin")
 	if err != nil {
 		t.Error(err)